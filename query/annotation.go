@@ -0,0 +1,231 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Position locates an Annotation within the original query text.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// Annotation describes a non-fatal issue noticed while parsing a query: one
+// that doesn't stop the query from being valid, but that a linter or IDE
+// plugin would want to surface. Code is stable across releases so tooling
+// can key behaviour (e.g. suppressions) off it.
+type Annotation struct {
+	Pos     Position
+	Code    string
+	Message string
+}
+
+// Annotation codes. These are part of the package's API surface: once
+// published, a code's meaning should not change.
+const (
+	codeTinyWindow       = "sase.W001"
+	codeMixedLiteralType = "sase.W002"
+	codeReservedAlias    = "sase.W003"
+	codeUnusedCapture    = "sase.W004"
+)
+
+// tinyWindow is the WITHIN threshold below which a match is considered
+// practically impossible: by the time two distinct events are observed,
+// real clocks and queues will almost always have burned more than this.
+const tinyWindow = time.Millisecond
+
+// reservedAliases are words that, while not reserved by this grammar, are
+// reserved (or have special meaning) in SQL-derived query languages; using
+// one as a capture alias is usually a copy-paste accident, not intent.
+var reservedAliases = map[string]bool{
+	"select": true, "where": true, "and": true, "or": true, "not": true,
+	"from": true, "group": true, "order": true, "table": true,
+	"insert": true, "update": true, "delete": true, "null": true,
+}
+
+// annotate runs every check against the just-parsed query and returns the
+// resulting annotations, in a stable order.
+func annotate(q *Query, src string) []Annotation {
+	var anns []Annotation
+
+	if q.window > 0 && q.window < tinyWindow {
+		anns = append(anns, Annotation{
+			Pos:     positionAt(src, q.windowPos),
+			Code:    codeTinyWindow,
+			Message: fmt.Sprintf("WITHIN %s is so small that a match is practically impossible", q.window),
+		})
+	}
+
+	for _, alias := range q.captureOrder {
+		if reservedAliases[strings.ToLower(alias)] {
+			anns = append(anns, Annotation{
+				Pos:     positionAt(src, aliasPos(q.pattern, alias)),
+				Code:    codeReservedAlias,
+				Message: fmt.Sprintf("capture alias %q shadows a word reserved in SQL-derived query languages", alias),
+			})
+		}
+	}
+
+	if q.where != nil {
+		anns = append(anns, mixedLiteralAnnotations(q, src)...)
+		anns = append(anns, unusedCaptureAnnotations(q, src)...)
+	}
+
+	return anns
+}
+
+// mixedLiteralAnnotations flags an attribute compared against a string
+// literal in one clause and a numeric literal in another, which is almost
+// always a sign the attribute's type was assumed rather than checked.
+func mixedLiteralAnnotations(q *Query, src string) []Annotation {
+	type sighting struct {
+		hasString, hasNumber bool
+		pos                  int
+	}
+	seen := map[string]*sighting{}
+	var order []string
+
+	walkPredicate(q.where, func(c *comparison) {
+		if c.lhs.isLiteral() || !c.rhs.isLiteral() {
+			return
+		}
+		key := c.lhs.alias + "." + c.lhs.attr
+		s, ok := seen[key]
+		if !ok {
+			s = &sighting{pos: c.rhs.pos}
+			seen[key] = s
+			order = append(order, key)
+		}
+		if c.rhs.isString {
+			s.hasString = true
+		}
+		if c.rhs.isNumber {
+			s.hasNumber = true
+		}
+	}, nil)
+
+	var anns []Annotation
+	for _, key := range order {
+		s := seen[key]
+		if s.hasString && s.hasNumber {
+			anns = append(anns, Annotation{
+				Pos:     positionAt(src, s.pos),
+				Code:    codeMixedLiteralType,
+				Message: fmt.Sprintf("%s is compared against both string and numeric literals", key),
+			})
+		}
+	}
+	return anns
+}
+
+// unusedCaptureAnnotations flags a capture alias declared by the EVENT
+// clause but never referenced by any WHERE comparison.
+func unusedCaptureAnnotations(q *Query, src string) []Annotation {
+	referenced := map[string]bool{}
+	walkPredicate(q.where, nil, func(o operand) {
+		if !o.isLiteral() {
+			referenced[o.alias] = true
+		}
+	})
+
+	var anns []Annotation
+	for _, alias := range q.captureOrder {
+		if !referenced[alias] {
+			anns = append(anns, Annotation{
+				Pos:     positionAt(src, aliasPos(q.pattern, alias)),
+				Code:    codeUnusedCapture,
+				Message: fmt.Sprintf("capture %q is never referenced in WHERE", alias),
+			})
+		}
+	}
+	return anns
+}
+
+// walkPredicate visits every node in a predicate tree. onCmp, if non-nil,
+// is called for each comparison node; onField, if non-nil, is called for
+// every field operand referenced anywhere (both sides of a comparison, or
+// the field of a LIKE/MATCHES/IN test).
+func walkPredicate(p predicate, onCmp func(*comparison), onField func(operand)) {
+	if p == nil {
+		return
+	}
+	switch n := p.(type) {
+	case *comparison:
+		if onCmp != nil {
+			onCmp(n)
+		}
+		if onField != nil {
+			if !n.lhs.isLiteral() {
+				onField(n.lhs)
+			}
+			if !n.rhs.isLiteral() {
+				onField(n.rhs)
+			}
+		}
+	case *andPredicate:
+		for _, c := range n.clauses {
+			walkPredicate(c, onCmp, onField)
+		}
+	case *orPredicate:
+		for _, c := range n.clauses {
+			walkPredicate(c, onCmp, onField)
+		}
+	case *notPredicate:
+		walkPredicate(n.inner, onCmp, onField)
+	case *likePredicate:
+		if onField != nil {
+			onField(n.field)
+		}
+	case *regexPredicate:
+		if onField != nil {
+			onField(n.field)
+		}
+	case *inPredicate:
+		if onField != nil {
+			onField(n.field)
+		}
+	}
+}
+
+// aliasPos finds the byte offset of alias's declaration within pattern.
+func aliasPos(pattern eventPattern, alias string) int {
+	var pos int
+	var walk func(eventPattern)
+	walk = func(p eventPattern) {
+		switch n := p.(type) {
+		case *capturePattern:
+			if n.alias == alias {
+				pos = n.aliasPos
+			}
+			if n.until != nil && n.until.alias == alias {
+				pos = n.until.aliasPos
+			}
+		case *seqPattern:
+			for _, item := range n.items {
+				walk(item)
+			}
+		case *anyPattern:
+			for _, item := range n.items {
+				walk(item)
+			}
+		}
+	}
+	walk(pattern)
+	return pos
+}
+
+// positionAt converts a byte offset into src to a 1-based line/column.
+func positionAt(src string, offset int) Position {
+	line, col := 1, 1
+	for i := 0; i < offset && i < len(src); i++ {
+		if src[i] == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return Position{Line: line, Column: col}
+}
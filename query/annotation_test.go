@@ -0,0 +1,51 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func annotationCodes(anns []Annotation) []string {
+	codes := make([]string, len(anns))
+	for i, a := range anns {
+		codes[i] = a.Code
+	}
+	return codes
+}
+
+func TestAnnotations(t *testing.T) {
+	expectations := map[string][]string{ // query: expected annotation codes, in order
+		"EVENT a b WHERE b.foo == 'bar'":                                  nil,
+		"EVENT a b WITHIN 1ns":                                            {codeTinyWindow},
+		"EVENT a select":                                                  {codeReservedAlias},
+		"EVENT a b WHERE b.foo == 'bar' AND b.foo == 1.0":                 {codeMixedLiteralType},
+		"EVENT SEQ(t1 e1, t2 e2, ANY(t3 e3, t4 e4)) WHERE e1.a1 == e2.a2": {codeUnusedCapture, codeUnusedCapture},
+	}
+
+	for queryText, expectedCodes := range expectations {
+		q, err := Parse(queryText)
+		require.NoError(t, err, "query %q should still parse successfully", queryText)
+		require.ElementsMatch(t, expectedCodes, annotationCodes(q.Annotations()),
+			"unexpected annotations for %q", queryText)
+	}
+}
+
+func TestAnnotationsHavePositions(t *testing.T) {
+	q, err := Parse("EVENT a b WITHIN 1ns")
+	require.NoError(t, err)
+	anns := q.Annotations()
+	require.Len(t, anns, 1)
+	require.Equal(t, 1, anns[0].Pos.Line)
+	require.Greater(t, anns[0].Pos.Column, 0)
+}
+
+func TestParseWithAnnotations(t *testing.T) {
+	q, anns, err := ParseWithAnnotations("EVENT a select")
+	require.NoError(t, err)
+	require.NotNil(t, q)
+	require.Equal(t, []string{codeReservedAlias}, annotationCodes(anns))
+
+	_, _, err = ParseWithAnnotations("EVENT")
+	require.Error(t, err)
+}
@@ -0,0 +1,195 @@
+package query
+
+import "regexp"
+
+// eventPattern is the parsed form of the EVENT clause: a single capture, or
+// a SEQ/ANY grouping of nested patterns. Implementations are plain structs
+// so walking the AST never needs reflection.
+type eventPattern interface {
+	isEventPattern()
+}
+
+// capturePattern binds one event, of the given type, to an alias that WHERE
+// clauses (and, later, the matcher) can refer back to. A negated capture
+// ("!(t e)") must not appear anywhere inside the pattern's window.
+//
+// A capture marked kleene ("t e+") binds zero or more events instead of
+// exactly one, making alias a list capture: WHERE sees it via indexing
+// ("e[i].attr") or an aggregate ("COUNT(e)", "SUM(e.attr)", ...) rather than
+// a bare "e.attr". until, if non-nil, is the capture that ends the Kleene
+// run ("t e+ UNTIL (u f)"); the terminating event itself is not added to e.
+type capturePattern struct {
+	eventType string
+	alias     string
+	aliasPos  int // byte offset of the alias token, for annotations
+	negated   bool
+	kleene    bool
+	until     *capturePattern
+}
+
+// seqPattern requires its items to match in order, each after the previous.
+// A contiguous seqPattern ("SEQ+(...)") additionally forbids any intervening
+// event of a type declared elsewhere in the query between consecutive items.
+type seqPattern struct {
+	items      []eventPattern
+	contiguous bool
+}
+
+// anyPattern matches if any one of its items matches; order is irrelevant.
+type anyPattern struct {
+	items []eventPattern
+}
+
+func (*capturePattern) isEventPattern() {}
+func (*seqPattern) isEventPattern()     {}
+func (*anyPattern) isEventPattern()     {}
+
+// compareOp is a WHERE-clause comparison operator.
+type compareOp int
+
+const (
+	opEq compareOp = iota
+	opNeq
+	opLt
+	opLte
+	opGt
+	opGte
+)
+
+func (op compareOp) String() string {
+	switch op {
+	case opEq:
+		return "=="
+	case opNeq:
+		return "!="
+	case opLt:
+		return "<"
+	case opLte:
+		return "<="
+	case opGt:
+		return ">"
+	case opGte:
+		return ">="
+	default:
+		return "?"
+	}
+}
+
+// aggFunc is an aggregate function applied to a Kleene list capture in a
+// WHERE clause, e.g. "COUNT(e)" or "SUM(e.attr)".
+type aggFunc int
+
+const (
+	aggNone aggFunc = iota
+	aggCount
+	aggSum
+	aggAvg
+	aggFirst
+	aggLast
+)
+
+func (a aggFunc) String() string {
+	switch a {
+	case aggCount:
+		return "COUNT"
+	case aggSum:
+		return "SUM"
+	case aggAvg:
+		return "AVG"
+	case aggFirst:
+		return "FIRST"
+	case aggLast:
+		return "LAST"
+	default:
+		return ""
+	}
+}
+
+// operand is one side of a comparison: <alias>.<attr>, a literal
+// string/number, an indexed element of a list capture ("e[i].attr"), or an
+// aggregate over one ("COUNT(e)", "SUM(e.attr)", ...). Exactly one of the
+// literal, indexed, and aggregate forms is populated on a non-literal
+// operand; isLiteral reports the literal case.
+type operand struct {
+	alias string // empty when this operand is a literal
+	attr  string
+
+	isString bool
+	isNumber bool
+	str      string
+	num      float64
+
+	index *int    // non-nil for "alias[index].attr"
+	agg   aggFunc // aggNone unless this operand is an aggregate over alias
+
+	pos int // byte offset of the operand's token, for annotations
+}
+
+func (o operand) isLiteral() bool { return o.alias == "" && o.agg == aggNone }
+
+// comparison is a single "alias.attr OP alias.attr" or "alias.attr OP
+// literal" test. It is itself a predicate, so a WHERE clause with no
+// boolean connectives at all is just a bare *comparison.
+type comparison struct {
+	lhs operand
+	op  compareOp
+	rhs operand
+}
+
+// predicate is a WHERE-clause boolean expression: a comparison, a LIKE /
+// MATCHES / IN test, or one of these combined with AND, OR, and NOT.
+// Parenthesized groups don't get their own node; grouping is already
+// captured by which predicate is whose child.
+type predicate interface {
+	isPredicate()
+}
+
+func (*comparison) isPredicate() {}
+
+// andPredicate is a conjunction, e.g. "a.x == 1 AND a.y != 2". AND binds
+// tighter than OR, so an andPredicate can itself be one operand of an
+// orPredicate, but never the reverse without an explicit parenthesized
+// group in the source.
+type andPredicate struct {
+	clauses []predicate
+}
+
+// orPredicate is a disjunction, e.g. "a.x == 1 OR a.y != 2".
+type orPredicate struct {
+	clauses []predicate
+}
+
+// notPredicate negates its operand, e.g. "NOT a.x == 1".
+type notPredicate struct {
+	inner predicate
+}
+
+// likePredicate is a SQL-style "alias.attr LIKE 'pattern'" test, where '%'
+// matches any run of characters and '_' matches exactly one. re is pattern
+// compiled to an equivalent regexp at parse time.
+type likePredicate struct {
+	field   operand
+	pattern string
+	re      *regexp.Regexp
+}
+
+// regexPredicate is an "alias.attr MATCHES /pattern/" test. re is compiled
+// at parse time so a bad pattern fails parsing rather than matching.
+type regexPredicate struct {
+	field   operand
+	pattern string
+	re      *regexp.Regexp
+}
+
+// inPredicate is an "alias.attr IN (v1, v2, ...)" set-membership test.
+type inPredicate struct {
+	field  operand
+	values []operand
+}
+
+func (*andPredicate) isPredicate()   {}
+func (*orPredicate) isPredicate()    {}
+func (*notPredicate) isPredicate()   {}
+func (*likePredicate) isPredicate()  {}
+func (*regexPredicate) isPredicate() {}
+func (*inPredicate) isPredicate()    {}
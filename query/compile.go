@@ -0,0 +1,391 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// CancelFunc stops a subscription started by Matcher.Subscribe, releasing
+// its channel. It is safe to call more than once.
+type CancelFunc func()
+
+// Matcher is a compiled Query. Compiling separates the one-time cost of
+// turning query text into an executable form from the per-event cost of
+// evaluating it, so long-lived queries don't re-walk the AST on every
+// candidate binding or event.
+type Matcher interface {
+	// Query returns the Query this Matcher was compiled from.
+	Query() *Query
+
+	// Eval reports whether the query's WHERE predicate holds for the given
+	// capture bindings (alias -> attribute name -> value). A query with no
+	// WHERE clause always evaluates to true. bindings has no way to express
+	// a Kleene-plus capture's list of events, so a predicate that reads one
+	// via indexing or an aggregate function always evaluates that part as
+	// false; such queries should be driven through Push instead.
+	Eval(bindings map[string]map[string]any) bool
+
+	// Push feeds ev into the matcher's partial-match state and returns any
+	// complete matches it produced. It also notifies active subscribers.
+	Push(ev Event) []Match
+
+	// Subscribe registers a new listener for matches produced by future
+	// Push calls. The returned channel is closed, and the subscription
+	// removed, when ctx is done or the returned CancelFunc is called.
+	Subscribe(ctx context.Context) (<-chan Match, CancelFunc)
+}
+
+// attrLookup resolves operands to their runtime values: attr backs a plain
+// "alias.attr" lookup, list backs indexed ("alias[i].attr") operands by
+// returning everything bound so far to a Kleene-plus capture, and closed
+// reports whether that capture's Kleene-plus run has finished (i.e. its
+// UNTIL terminator has already been seen) so aggregate (COUNT/SUM/AVG/
+// FIRST/LAST) operands know when their value is final rather than still
+// growing. Eval backs it with a plain map (list always reports not-found,
+// since a static binding has no notion of a list capture; closed is always
+// true, since a static binding has no notion of "still growing" either);
+// the NFA backs it with live Events.
+type attrLookup struct {
+	attr   func(alias, attr string) (any, bool)
+	list   func(alias string) ([]Event, bool)
+	closed func(alias string) bool
+}
+
+// predFunc is a compiled predicate: a closure with no remaining AST
+// dispatch, evaluated directly against an attrLookup.
+type predFunc func(lookup attrLookup) bool
+
+type compiledMatcher struct {
+	query   *Query
+	eval    predFunc
+	pattern *compiledPattern
+
+	mu        sync.Mutex
+	instances []*instance
+	subs      []*subscriber
+}
+
+func (m *compiledMatcher) Query() *Query { return m.query }
+
+func (m *compiledMatcher) Eval(bindings map[string]map[string]any) bool {
+	if m.eval == nil {
+		return true
+	}
+	return m.eval(mapLookup(bindings))
+}
+
+func mapLookup(bindings map[string]map[string]any) attrLookup {
+	return attrLookup{
+		attr: func(alias, attr string) (any, bool) {
+			attrs, ok := bindings[alias]
+			if !ok {
+				return nil, false
+			}
+			v, ok := attrs[attr]
+			return v, ok
+		},
+		list:   func(string) ([]Event, bool) { return nil, false },
+		closed: func(string) bool { return true },
+	}
+}
+
+// Compile lowers the Query's AST into a Matcher. Callers that hold on to a
+// query (e.g. a long-running subscription) should compile it once and reuse
+// the Matcher, rather than re-parsing or re-walking the AST per event.
+func (q *Query) Compile() (Matcher, error) {
+	var eval predFunc
+	if q.where != nil {
+		var err error
+		eval, err = compilePredicate(q.where)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pattern := compilePattern(q.pattern)
+	if q.window <= 0 && len(pattern.negAfter[len(pattern.steps)]) > 0 {
+		// A negated capture at the end of the pattern can only be ruled
+		// out once its window closes without it occurring (see
+		// completeOrHold); with no WITHIN clause that window never closes,
+		// so the match could never be confirmed or released.
+		return nil, &ParseError{msg: "a negated capture at the end of the pattern requires a WITHIN window, so its absence can eventually be confirmed"}
+	}
+	return &compiledMatcher{
+		query:   q,
+		eval:    eval,
+		pattern: pattern,
+	}, nil
+}
+
+// MustCompile parses and compiles queryText, panicking on error. It exists
+// for the same reason MustParse does: package-level query literals where a
+// failure is a programmer error, not a runtime one.
+func MustCompile(queryText string) Matcher {
+	q := MustParse(queryText)
+	m, err := q.Compile()
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func compilePredicate(p predicate) (predFunc, error) {
+	switch n := p.(type) {
+	case *comparison:
+		return compileComparison(n)
+	case *andPredicate:
+		fns, err := compilePredicates(n.clauses)
+		if err != nil {
+			return nil, err
+		}
+		return func(lookup attrLookup) bool {
+			for _, fn := range fns {
+				if !fn(lookup) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case *orPredicate:
+		fns, err := compilePredicates(n.clauses)
+		if err != nil {
+			return nil, err
+		}
+		return func(lookup attrLookup) bool {
+			for _, fn := range fns {
+				if fn(lookup) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case *notPredicate:
+		fn, err := compilePredicate(n.inner)
+		if err != nil {
+			return nil, err
+		}
+		return func(lookup attrLookup) bool { return !fn(lookup) }, nil
+	case *likePredicate:
+		return compileFieldPredicate(n.field, func(v any) bool {
+			s, ok := v.(string)
+			return ok && n.re.MatchString(s)
+		}), nil
+	case *regexPredicate:
+		return compileFieldPredicate(n.field, func(v any) bool {
+			s, ok := v.(string)
+			return ok && n.re.MatchString(s)
+		}), nil
+	case *inPredicate:
+		values := n.values
+		return compileFieldPredicate(n.field, func(v any) bool {
+			for _, lit := range values {
+				if literalEquals(lit, v) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	default:
+		return nil, &ParseError{msg: "compile: unsupported predicate node"}
+	}
+}
+
+func compilePredicates(preds []predicate) ([]predFunc, error) {
+	fns := make([]predFunc, len(preds))
+	for i, p := range preds {
+		fn, err := compilePredicate(p)
+		if err != nil {
+			return nil, err
+		}
+		fns[i] = fn
+	}
+	return fns, nil
+}
+
+func compileComparison(c *comparison) (predFunc, error) {
+	op := c.op
+	lhs, rhs := c.lhs, c.rhs
+	return func(lookup attrLookup) bool {
+		lv, lok := resolveOperand(lookup, lhs)
+		rv, rok := resolveOperand(lookup, rhs)
+		if !lok || !rok {
+			return false
+		}
+		return compareValues(lv, op, rv)
+	}, nil
+}
+
+// compileFieldPredicate compiles a field-based test (LIKE, MATCHES, IN)
+// into a predFunc: false if the field isn't bound yet, pred(value) once it
+// is. The parser never builds a likePredicate/regexPredicate/inPredicate
+// over an indexed or aggregate field, so field is always a plain
+// "alias.attr" here.
+func compileFieldPredicate(field operand, pred func(any) bool) predFunc {
+	return func(lookup attrLookup) bool {
+		v, ok := lookup.attr(field.alias, field.attr)
+		if !ok {
+			return false
+		}
+		return pred(v)
+	}
+}
+
+func literalEquals(lit operand, v any) bool {
+	if lit.isString {
+		s, ok := v.(string)
+		return ok && s == lit.str
+	}
+	if lit.isNumber {
+		n, ok := asFloat(v)
+		return ok && n == lit.num
+	}
+	return false
+}
+
+func resolveOperand(lookup attrLookup, o operand) (any, bool) {
+	if o.isLiteral() {
+		if o.isString {
+			return o.str, true
+		}
+		return o.num, true
+	}
+	if o.agg != aggNone {
+		return resolveAggregate(lookup, o)
+	}
+	if o.index != nil {
+		events, ok := lookup.list(o.alias)
+		if !ok {
+			return nil, false
+		}
+		if *o.index < 0 || *o.index >= len(events) {
+			if lookup.closed(o.alias) {
+				// The capture has finished growing and will never reach
+				// this index, so the comparison can be resolved now
+				// (against a value nothing else will ever equal) rather
+				// than left unknown.
+				return nil, true
+			}
+			return nil, false
+		}
+		return events[*o.index].Attr(o.attr)
+	}
+	return lookup.attr(o.alias, o.attr)
+}
+
+// resolveAggregate evaluates a COUNT/SUM/AVG/FIRST/LAST operand against
+// everything bound to its Kleene-plus capture. It reports not-ok (as
+// opposed to a zero-ish value) while the capture's run hasn't closed yet, so
+// partial-match evaluation treats an aggregate the same as any other
+// not-yet-resolvable operand rather than judging it against a value that
+// could still change as more events are captured.
+func resolveAggregate(lookup attrLookup, o operand) (any, bool) {
+	if !lookup.closed(o.alias) {
+		return nil, false
+	}
+	events, ok := lookup.list(o.alias)
+	if !ok {
+		return nil, false
+	}
+	switch o.agg {
+	case aggCount:
+		return float64(len(events)), true
+	case aggFirst:
+		if len(events) == 0 {
+			return nil, false
+		}
+		return events[0].Attr(o.attr)
+	case aggLast:
+		if len(events) == 0 {
+			return nil, false
+		}
+		return events[len(events)-1].Attr(o.attr)
+	case aggSum, aggAvg:
+		if len(events) == 0 {
+			return nil, false
+		}
+		var sum float64
+		for _, ev := range events {
+			v, ok := ev.Attr(o.attr)
+			if !ok {
+				return nil, false
+			}
+			n, ok := asFloat(v)
+			if !ok {
+				return nil, false
+			}
+			sum += n
+		}
+		if o.agg == aggAvg {
+			return sum / float64(len(events)), true
+		}
+		return sum, true
+	default:
+		return nil, false
+	}
+}
+
+func compareValues(lv any, op compareOp, rv any) bool {
+	if ln, lok := asFloat(lv); lok {
+		if rn, rok := asFloat(rv); rok {
+			return compareOrdered(ln, rn, op)
+		}
+	}
+	if ls, lok := lv.(string); lok {
+		if rs, rok := rv.(string); rok {
+			return compareOrdered(compareStrings(ls, rs), 0, op)
+		}
+	}
+	switch op {
+	case opEq:
+		return lv == rv
+	case opNeq:
+		return lv != rv
+	default:
+		return false
+	}
+}
+
+func asFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func compareOrdered[T int | float64](l, r T, op compareOp) bool {
+	switch op {
+	case opEq:
+		return l == r
+	case opNeq:
+		return l != r
+	case opLt:
+		return l < r
+	case opLte:
+		return l <= r
+	case opGt:
+		return l > r
+	case opGte:
+		return l >= r
+	default:
+		return false
+	}
+}
+
+// compareStrings is a small three-way compare, avoiding a dependency on
+// "strings" solely for this one spot.
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
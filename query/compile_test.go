@@ -0,0 +1,95 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	q, err := Parse("EVENT SEQ(t1 e1, t2 e2) WHERE e1.foo == e2.bar AND e1.n > 1.0")
+	require.NoError(t, err)
+
+	m, err := q.Compile()
+	require.NoError(t, err)
+	require.Same(t, q, m.Query())
+
+	match := map[string]map[string]any{
+		"e1": {"foo": "x", "n": 2.0},
+		"e2": {"bar": "x"},
+	}
+	require.True(t, m.Eval(match))
+
+	noMatch := map[string]map[string]any{
+		"e1": {"foo": "x", "n": 0.5},
+		"e2": {"bar": "x"},
+	}
+	require.False(t, m.Eval(noMatch))
+}
+
+func TestCompileNoWhere(t *testing.T) {
+	q, err := Parse("EVENT t1 e1")
+	require.NoError(t, err)
+
+	m, err := q.Compile()
+	require.NoError(t, err)
+	require.True(t, m.Eval(nil), "a query with no WHERE clause should always evaluate true")
+}
+
+func TestCompileRejectsTrailingNegationWithoutWindow(t *testing.T) {
+	q, err := Parse("EVENT SEQ(a e1, b e2, !(c e3))")
+	require.NoError(t, err, "parsing alone doesn't require a WITHIN window")
+
+	_, err = q.Compile()
+	require.Error(t, err, "a trailing negation's absence can never be confirmed without a window to close")
+}
+
+func TestCompileAllowsTrailingNegationWithWindow(t *testing.T) {
+	q, err := Parse("EVENT SEQ(a e1, b e2, !(c e3)) WITHIN 1h")
+	require.NoError(t, err)
+
+	_, err = q.Compile()
+	require.NoError(t, err)
+}
+
+func TestMustCompile(t *testing.T) {
+	require.NotPanics(t, func() {
+		MustCompile("EVENT t1 e1 WHERE e1.foo == 'bar'")
+	})
+	require.Panics(t, func() {
+		MustCompile("EVENT")
+	})
+}
+
+func BenchmarkCompile(b *testing.B) {
+	queryText := "EVENT SEQ(t1 e1, ANY(t2 e2, t3 e3), !(t4 e4), t5 e5) WHERE e1.foo == e2.bar AND e3.baz == e4.boop WITHIN 2h"
+	q, err := Parse(queryText)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := q.Compile(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	q, err := Parse("EVENT SEQ(t1 e1, t2 e2) WHERE e1.foo == e2.bar AND e1.n > 1.0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	m, err := q.Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	bindings := map[string]map[string]any{
+		"e1": {"foo": "x", "n": 2.0},
+		"e2": {"bar": "x"},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Eval(bindings)
+	}
+}
@@ -0,0 +1,30 @@
+package query
+
+import "time"
+
+// Event is a single occurrence fed into a Matcher via Push. Implementations
+// are supplied by the caller; this package only ever reads from them.
+type Event interface {
+	// Type is the event's SASE type, matched against the type names
+	// declared in the query's EVENT clause (e.g. "a" in "SEQ(a e1, ...)").
+	Type() string
+
+	// Attr returns the named attribute's value, and whether it was
+	// present. It backs WHERE-clause field references such as "e1.foo".
+	Attr(name string) (any, bool)
+
+	// Timestamp is the event's logical time. Matchers expire partial
+	// matches based on the timestamps of the events pushed to them, not
+	// wall-clock time, so callers driving a Matcher from a replay or a
+	// test can do so deterministically.
+	Timestamp() time.Time
+}
+
+// Match is a complete match of a query's pattern, with each capture alias
+// bound to the Event that satisfied it. ListCaptures holds the same thing
+// for Kleene-plus (list) captures: every event bound to that alias, in the
+// order they were observed.
+type Match struct {
+	Captures     map[string]Event
+	ListCaptures map[string][]Event
+}
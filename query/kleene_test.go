@@ -0,0 +1,93 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcherPushKleeneUntil(t *testing.T) {
+	m := MustCompile("EVENT SEQ(t1 login, t2 fail+ UNTIL (t3 logout)) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "t1", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "t2", ts: at(time.Minute)}))
+	require.Empty(t, m.Push(testEvent{typ: "t2", ts: at(2 * time.Minute)}))
+	matches := m.Push(testEvent{typ: "t3", ts: at(3 * time.Minute)})
+
+	require.Len(t, matches, 1)
+	require.Equal(t, "t1", matches[0].Captures["login"].Type())
+	require.Equal(t, "t3", matches[0].Captures["logout"].Type())
+	require.Len(t, matches[0].ListCaptures["fail"], 2)
+}
+
+func TestMatcherPushKleeneUntilRequiresAtLeastOne(t *testing.T) {
+	m := MustCompile("EVENT SEQ(t1 login, t2 fail+ UNTIL (t3 logout)) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "t1", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "t3", ts: at(time.Minute)}),
+		"UNTIL shouldn't end the run before at least one fail has been captured")
+}
+
+// TestMatcherPushSeqContiguousDropsOnInterveningDeclaredType checks that an
+// intervening declared-type event doesn't just leave a SEQ+ instance
+// pending: it's dropped outright, so a later match can only come from an
+// instance that started at (or after) the intervening event, never one that
+// straddles it.
+func TestMatcherPushSeqContiguousDropsOnInterveningDeclaredType(t *testing.T) {
+	m := MustCompile("EVENT SEQ+(a e1, b e2) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", attrs: map[string]any{"n": 1.0}, ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "a", attrs: map[string]any{"n": 2.0}, ts: at(time.Minute)}),
+		"a second declared-type event before b should break SEQ+ contiguity for the first instance")
+	matches := m.Push(testEvent{typ: "b", ts: at(2 * time.Minute)})
+
+	require.Len(t, matches, 1, "the second 'a' is still immediately followed by 'b', so that pair is a valid match")
+	n, _ := matches[0].Captures["e1"].Attr("n")
+	require.Equal(t, 2.0, n, "the match must come from the second 'a', proving the first instance was dropped rather than left pending")
+}
+
+func TestMatcherPushSeqContiguousIgnoresUndeclaredTypes(t *testing.T) {
+	m := MustCompile("EVENT SEQ+(a e1, b e2) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "x", ts: at(time.Minute)}),
+		"an undeclared event type should not break SEQ+ contiguity")
+	matches := m.Push(testEvent{typ: "b", ts: at(2 * time.Minute)})
+	require.Len(t, matches, 1)
+}
+
+func TestEvalKleeneAggregates(t *testing.T) {
+	q := MustParse("EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE COUNT(e2) == 2 AND SUM(e2.n) == 3.0 AND AVG(e2.n) == 1.5")
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	require.Empty(t, m.Push(testEvent{typ: "t1", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "t2", ts: at(time.Minute), attrs: map[string]any{"n": 1.0}}))
+	require.Empty(t, m.Push(testEvent{typ: "t2", ts: at(2 * time.Minute), attrs: map[string]any{"n": 2.0}}))
+	matches := m.Push(testEvent{typ: "t3", ts: at(3 * time.Minute)})
+	require.Len(t, matches, 1)
+}
+
+func TestEvalKleeneIndexOutOfRangeAfterClose(t *testing.T) {
+	q := MustParse("EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE e2[5].n == 1.0")
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	require.Empty(t, m.Push(testEvent{typ: "t1", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "t2", ts: at(time.Minute), attrs: map[string]any{"n": 1.0}}))
+	matches := m.Push(testEvent{typ: "t3", ts: at(2 * time.Minute)})
+	require.Empty(t, matches, "e2[5] can never exist once the run closes with only one captured event")
+}
+
+func TestEvalKleeneIndexedCapture(t *testing.T) {
+	q := MustParse("EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE e2[0].n == 1.0")
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	require.Empty(t, m.Push(testEvent{typ: "t1", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "t2", ts: at(time.Minute), attrs: map[string]any{"n": 2.0}}),
+		"the first fail's n is 2, not 1, so the match should stay pending, not complete or die")
+	matches := m.Push(testEvent{typ: "t3", ts: at(2 * time.Minute)})
+	require.Empty(t, matches, "e2[0].n == 1.0 should never hold for this run")
+}
@@ -0,0 +1,328 @@
+package query
+
+import (
+	"fmt"
+	"sync"
+)
+
+// tokenKind identifies the lexical category of a token produced by the
+// scanner.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeywordEvent
+	tokKeywordSeq
+	tokKeywordAny
+	tokKeywordWhere
+	tokKeywordWithin
+	tokKeywordAnd
+	tokKeywordOr
+	tokKeywordNot
+	tokKeywordLike
+	tokKeywordMatches
+	tokKeywordIn
+	tokKeywordUntil
+	tokKeywordCount
+	tokKeywordSum
+	tokKeywordAvg
+	tokKeywordFirst
+	tokKeywordLast
+	tokString
+	tokNumber
+	tokDuration
+	tokRegex
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokDot
+	tokBang
+	tokPlus
+	tokSemicolon
+	tokEq
+	tokNeq
+	tokLt
+	tokLte
+	tokGt
+	tokGte
+	tokError
+)
+
+var keywords = map[string]tokenKind{
+	"EVENT":   tokKeywordEvent,
+	"SEQ":     tokKeywordSeq,
+	"ANY":     tokKeywordAny,
+	"WHERE":   tokKeywordWhere,
+	"WITHIN":  tokKeywordWithin,
+	"AND":     tokKeywordAnd,
+	"OR":      tokKeywordOr,
+	"NOT":     tokKeywordNot,
+	"LIKE":    tokKeywordLike,
+	"MATCHES": tokKeywordMatches,
+	"IN":      tokKeywordIn,
+	"UNTIL":   tokKeywordUntil,
+	"COUNT":   tokKeywordCount,
+	"SUM":     tokKeywordSum,
+	"AVG":     tokKeywordAvg,
+	"FIRST":   tokKeywordFirst,
+	"LAST":    tokKeywordLast,
+}
+
+// token is a single lexeme produced by the scanner, tagged with the byte
+// offset it started at so the parser can report useful error positions.
+type token struct {
+	kind   tokenKind
+	pos    int
+	lexeme string
+}
+
+// lexer turns query text into a stream of tokens. A lexer is obtained from
+// lexerPool and returned via release so repeated Parse calls don't pay for a
+// fresh scanner and token slice every time.
+type lexer struct {
+	src  string
+	pos  int
+	toks []token
+}
+
+var lexerPool = sync.Pool{
+	New: func() any { return &lexer{} },
+}
+
+func newLexer(src string) *lexer {
+	l := lexerPool.Get().(*lexer)
+	l.src = src
+	l.pos = 0
+	l.toks = l.toks[:0]
+	return l
+}
+
+func (l *lexer) release() {
+	l.src = ""
+	lexerPool.Put(l)
+}
+
+// scan tokenizes the whole source string up front. Queries are short, so
+// trading a single pass for simpler parser code is worth it; the resulting
+// slice is reused from the lexer's scratch buffer.
+func (l *lexer) scan() ([]token, error) {
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		l.toks = append(l.toks, tok)
+		if tok.kind == tokEOF {
+			break
+		}
+	}
+	return l.toks, nil
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isAlpha(b byte) bool {
+	return b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isAlphaNum(b byte) bool {
+	return isAlpha(b) || isDigit(b)
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && isSpace(l.src[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	b := l.src[l.pos]
+	switch {
+	case b == '(':
+		l.pos++
+		return token{kind: tokLParen, pos: start, lexeme: "("}, nil
+	case b == ')':
+		l.pos++
+		return token{kind: tokRParen, pos: start, lexeme: ")"}, nil
+	case b == ',':
+		l.pos++
+		return token{kind: tokComma, pos: start, lexeme: ","}, nil
+	case b == '.':
+		l.pos++
+		return token{kind: tokDot, pos: start, lexeme: "."}, nil
+	case b == '[':
+		l.pos++
+		return token{kind: tokLBracket, pos: start, lexeme: "["}, nil
+	case b == ']':
+		l.pos++
+		return token{kind: tokRBracket, pos: start, lexeme: "]"}, nil
+	case b == '+':
+		l.pos++
+		return token{kind: tokPlus, pos: start, lexeme: "+"}, nil
+	case b == ';':
+		l.pos++
+		return token{kind: tokSemicolon, pos: start, lexeme: ";"}, nil
+	case b == '!':
+		if l.peekAt(1) == '=' {
+			l.pos += 2
+			return token{kind: tokNeq, pos: start, lexeme: "!="}, nil
+		}
+		l.pos++
+		return token{kind: tokBang, pos: start, lexeme: "!"}, nil
+	case b == '=':
+		if l.peekAt(1) == '=' {
+			l.pos += 2
+			return token{kind: tokEq, pos: start, lexeme: "=="}, nil
+		}
+		return token{}, l.errorf(start, "unexpected '='; did you mean '=='?")
+	case b == '<':
+		if l.peekAt(1) == '=' {
+			l.pos += 2
+			return token{kind: tokLte, pos: start, lexeme: "<="}, nil
+		}
+		l.pos++
+		return token{kind: tokLt, pos: start, lexeme: "<"}, nil
+	case b == '>':
+		if l.peekAt(1) == '=' {
+			l.pos += 2
+			return token{kind: tokGte, pos: start, lexeme: ">="}, nil
+		}
+		l.pos++
+		return token{kind: tokGt, pos: start, lexeme: ">"}, nil
+	case b == '\'' || b == '"':
+		return l.scanString(b)
+	case b == '/':
+		return l.scanRegex()
+	case b == '-' || isDigit(b):
+		return l.scanNumberOrDuration()
+	case isAlpha(b):
+		return l.scanIdent()
+	default:
+		return token{}, l.errorf(start, "unexpected character %q", b)
+	}
+}
+
+func (l *lexer) peekAt(offset int) byte {
+	if l.pos+offset >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos+offset]
+}
+
+func (l *lexer) scanIdent() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && isAlphaNum(l.src[l.pos]) {
+		l.pos++
+	}
+	lexeme := l.src[start:l.pos]
+	if kind, ok := keywords[lexeme]; ok {
+		return token{kind: kind, pos: start, lexeme: lexeme}, nil
+	}
+	return token{kind: tokIdent, pos: start, lexeme: lexeme}, nil
+}
+
+func (l *lexer) scanString(quote byte) (token, error) {
+	start := l.pos
+	l.pos++ // opening quote
+	for l.pos < len(l.src) && l.src[l.pos] != quote {
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{}, l.errorf(start, "unterminated string literal")
+	}
+	lexeme := l.src[start+1 : l.pos]
+	l.pos++ // closing quote
+	return token{kind: tokString, pos: start, lexeme: lexeme}, nil
+}
+
+// scanRegex scans a "/pattern/" literal for MATCHES. The pattern itself is
+// handed to regexp.Compile unmodified; there's no escaping of '/' within it.
+func (l *lexer) scanRegex() (token, error) {
+	start := l.pos
+	l.pos++ // opening '/'
+	for l.pos < len(l.src) && l.src[l.pos] != '/' {
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{}, l.errorf(start, "unterminated regex literal")
+	}
+	lexeme := l.src[start+1 : l.pos]
+	l.pos++ // closing '/'
+	return token{kind: tokRegex, pos: start, lexeme: lexeme}, nil
+}
+
+// durationUnits is checked longest-first so "ms" isn't mistaken for "m"
+// followed by a stray "s".
+var durationUnits = []string{"ns", "us", "µs", "ms", "s", "m", "h"}
+
+func (l *lexer) matchUnit() string {
+	for _, u := range durationUnits {
+		if l.pos+len(u) <= len(l.src) && l.src[l.pos:l.pos+len(u)] == u {
+			return u
+		}
+	}
+	return ""
+}
+
+// scanNumberOrDuration scans a signed numeric literal, e.g. "-1.0", or a
+// Go-style duration made of one or more number+unit components, e.g.
+// "1h10m20s100ns". The two share a lexer because they can't be told apart
+// until the digits have been consumed and we see whether a unit follows.
+func (l *lexer) scanNumberOrDuration() (token, error) {
+	start := l.pos
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if l.pos >= len(l.src) || !isDigit(l.src[l.pos]) {
+		return token{}, l.errorf(start, "expected digit after '-'")
+	}
+	l.scanDigits()
+
+	unit := l.matchUnit()
+	if unit == "" {
+		// Plain number; allow a single fractional part.
+		if l.pos < len(l.src) && l.src[l.pos] == '.' {
+			l.pos++
+			l.scanDigits()
+		}
+		return token{kind: tokNumber, pos: start, lexeme: l.src[start:l.pos]}, nil
+	}
+
+	// Duration: consume this component's unit, then keep consuming further
+	// digit(.digit)?unit components as long as they appear back-to-back.
+	for unit != "" {
+		l.pos += len(unit)
+		if l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.scanDigits()
+			if l.pos < len(l.src) && l.src[l.pos] == '.' {
+				l.pos++
+				l.scanDigits()
+			}
+			unit = l.matchUnit()
+			continue
+		}
+		break
+	}
+	return token{kind: tokDuration, pos: start, lexeme: l.src[start:l.pos]}, nil
+}
+
+func (l *lexer) scanDigits() {
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+}
+
+func (l *lexer) errorf(pos int, format string, args ...any) error {
+	return &ParseError{Pos: pos, msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,178 @@
+package query
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testEvent is the simplest possible Event implementation, used to drive
+// the matcher in these tests.
+type testEvent struct {
+	typ   string
+	attrs map[string]any
+	ts    time.Time
+}
+
+func (e testEvent) Type() string { return e.typ }
+func (e testEvent) Attr(name string) (any, bool) {
+	v, ok := e.attrs[name]
+	return v, ok
+}
+func (e testEvent) Timestamp() time.Time { return e.ts }
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func at(offset time.Duration) time.Time { return epoch.Add(offset) }
+
+func TestMatcherPushSequence(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, b e2) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	matches := m.Push(testEvent{typ: "b", ts: at(time.Minute)})
+	require.Len(t, matches, 1)
+	require.Equal(t, "a", matches[0].Captures["e1"].Type())
+	require.Equal(t, "b", matches[0].Captures["e2"].Type())
+}
+
+func TestMatcherPushRejectsUnrelatedTypes(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, b e2) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "x", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "x", ts: at(time.Minute)}))
+	matches := m.Push(testEvent{typ: "b", ts: at(2 * time.Minute)})
+	require.Len(t, matches, 1)
+}
+
+func TestMatcherPushNegationKillsInstance(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, !(c e2), b e3) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "c", ts: at(time.Minute)}))
+	require.Empty(t, m.Push(testEvent{typ: "b", ts: at(2 * time.Minute)}),
+		"an intervening 'c' event should have invalidated the partial match")
+}
+
+func TestMatcherPushTrailingNegationHeldUntilWindowExpires(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, b e2, !(c e3)) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "b", ts: at(time.Minute)}),
+		"the match can't be confirmed yet: the trailing negation still has its whole window left to not occur in")
+	matches := m.Push(testEvent{typ: "x", ts: at(2 * time.Hour)})
+	require.Len(t, matches, 1, "the window closed with no 'c', so the held match should now be released")
+	require.Equal(t, "a", matches[0].Captures["e1"].Type())
+	require.Equal(t, "b", matches[0].Captures["e2"].Type())
+}
+
+func TestMatcherPushTrailingNegationHeldInSeqPlusSurvivesDeclaredEvent(t *testing.T) {
+	m := MustCompile("EVENT SEQ+(a e1, b e2, !(c e3)) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "b", ts: at(time.Minute)}))
+	require.NotPanics(t, func() {
+		require.Empty(t, m.Push(testEvent{typ: "a", ts: at(2 * time.Minute)}),
+			"a held match has already satisfied every step, so SEQ+ contiguity no longer applies to it")
+	})
+	matches := m.Push(testEvent{typ: "x", ts: at(2 * time.Hour)})
+	require.Len(t, matches, 1, "the held match should still be released once its window closes")
+}
+
+func TestMatcherPushTrailingNegationKillsHeldMatch(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, b e2, !(c e3)) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "b", ts: at(time.Minute)}))
+	require.Empty(t, m.Push(testEvent{typ: "c", ts: at(2 * time.Minute)}),
+		"'c' arriving inside the window should kill the held match before it's ever released")
+	require.Empty(t, m.Push(testEvent{typ: "x", ts: at(2 * time.Hour)}),
+		"the held match was already killed, so the window expiring later must not release it")
+}
+
+func TestMatcherPushExpiresOnWindow(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, b e2) WITHIN 1m")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	require.Empty(t, m.Push(testEvent{typ: "b", ts: at(2 * time.Minute)}),
+		"match should have expired before the second event arrived")
+}
+
+func TestMatcherPushWhereIncremental(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, b e2) WHERE e1.x == e2.x")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0), attrs: map[string]any{"x": 1.0}}))
+	require.Empty(t, m.Push(testEvent{typ: "b", ts: at(time.Minute), attrs: map[string]any{"x": 2.0}}),
+		"mismatched x should not complete the match")
+	matches := m.Push(testEvent{typ: "b", ts: at(2 * time.Minute), attrs: map[string]any{"x": 1.0}})
+	require.Len(t, matches, 1)
+}
+
+func TestMatcherPushAny(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, ANY(b e2, c e3)) WITHIN 1h")
+
+	require.Empty(t, m.Push(testEvent{typ: "a", ts: at(0)}))
+	matches := m.Push(testEvent{typ: "c", ts: at(time.Minute)})
+	require.Len(t, matches, 1)
+	require.Equal(t, "c", matches[0].Captures["e3"].Type())
+}
+
+func TestMatcherSubscribeFanOut(t *testing.T) {
+	m := MustCompile("EVENT SEQ(a e1, b e2) WITHIN 1h")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch1, cancel1 := m.Subscribe(ctx)
+	defer cancel1()
+	ch2, cancel2 := m.Subscribe(ctx)
+	defer cancel2()
+
+	m.Push(testEvent{typ: "a", ts: at(0)})
+	m.Push(testEvent{typ: "b", ts: at(time.Minute)})
+
+	select {
+	case match := <-ch1:
+		require.Equal(t, "b", match.Captures["e2"].Type())
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for match on ch1")
+	}
+	select {
+	case match := <-ch2:
+		require.Equal(t, "b", match.Captures["e2"].Type())
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for match on ch2")
+	}
+}
+
+func TestMatcherSubscribeCancel(t *testing.T) {
+	m := MustCompile("EVENT a e1")
+
+	ch, cancel := m.Subscribe(context.Background())
+	cancel()
+
+	_, open := <-ch
+	require.False(t, open, "channel should be closed after cancel")
+}
+
+// TestMatcherSubscribeCancelDoesNotLeakGoroutine guards against Subscribe's
+// ctx-watcher goroutine surviving a manual cancel of a subscription backed
+// by a long-lived context (e.g. context.Background()): that goroutine must
+// exit via the cancel path, not just the ctx.Done() path.
+func TestMatcherSubscribeCancelDoesNotLeakGoroutine(t *testing.T) {
+	m := MustCompile("EVENT a e1")
+	before := runtime.NumGoroutine()
+
+	const subs = 200
+	for i := 0; i < subs; i++ {
+		_, cancel := m.Subscribe(context.Background())
+		cancel()
+	}
+
+	require.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= before+subs/2
+	}, time.Second, time.Millisecond, "ctx-watcher goroutines should exit once cancelled, not accumulate")
+}
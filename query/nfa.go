@@ -0,0 +1,481 @@
+package query
+
+import "time"
+
+// patternAlt is one alternative a pattern step can be satisfied by: an
+// event of eventType, bound to alias.
+type patternAlt struct {
+	eventType string
+	alias     string
+}
+
+// positiveStep is satisfied by any one of its alternatives: a single
+// alternative for a bare capture or a SEQ item, several for an ANY group.
+//
+// A kleene step instead represents a Kleene-plus run: each event matching
+// kleeneAlt extends its list capture without advancing stepIdx, until an
+// event matching untilAlt (if any) terminates the run, binds the
+// terminator as a scalar capture, and advances. A kleene step with no
+// untilAlt can only ever grow; see tryAdvanceKleene.
+type positiveStep struct {
+	alts []patternAlt
+
+	kleene    bool
+	kleeneAlt patternAlt
+	untilAlt  *patternAlt
+}
+
+// matchesEvent reports whether ev could be consumed by this step in any
+// way: as one of its alts, or (for a kleene step) as either the repeated
+// type or its UNTIL terminator. It's used to decide whether an event breaks
+// a SEQ+'s contiguity, not to actually advance an instance.
+func (s positiveStep) matchesEvent(ev Event) bool {
+	if s.kleene {
+		if ev.Type() == s.kleeneAlt.eventType {
+			return true
+		}
+		return s.untilAlt != nil && ev.Type() == s.untilAlt.eventType
+	}
+	_, ok := s.match(ev)
+	return ok
+}
+
+// compiledPattern is the EVENT clause lowered into a form the NFA engine
+// can run directly: an ordered list of required steps, the negated
+// captures that must not occur while waiting on a given step, and the
+// contiguity/declared-type bookkeeping SEQ+ needs.
+type compiledPattern struct {
+	steps []positiveStep
+	// negAfter[i] are alternatives that, if seen while an instance has
+	// completed exactly i of the steps (and so is waiting on steps[i]),
+	// invalidate that instance.
+	negAfter map[int][]patternAlt
+	// contiguousAt[i] marks that steps[i] must be satisfied immediately:
+	// an instance waiting on it that sees an event of any declared type
+	// other than what it's waiting for is dropped, rather than left
+	// pending. Set by SEQ+ for every item after the first in its group.
+	contiguousAt map[int]bool
+	// declaredTypes is every event type named anywhere in the query's
+	// EVENT clause, used to decide whether an intervening event breaks a
+	// SEQ+'s contiguity.
+	declaredTypes map[string]bool
+}
+
+func compilePattern(p eventPattern) *compiledPattern {
+	cp := &compiledPattern{
+		negAfter:      map[int][]patternAlt{},
+		contiguousAt:  map[int]bool{},
+		declaredTypes: collectDeclaredTypes(p),
+	}
+	flattenPattern(cp, p)
+	return cp
+}
+
+// collectDeclaredTypes gathers every event type named anywhere in the
+// EVENT clause, including negated captures, Kleene-plus captures, and
+// UNTIL terminators.
+func collectDeclaredTypes(p eventPattern) map[string]bool {
+	out := map[string]bool{}
+	var walk func(eventPattern)
+	walk = func(p eventPattern) {
+		switch n := p.(type) {
+		case *capturePattern:
+			out[n.eventType] = true
+			if n.until != nil {
+				out[n.until.eventType] = true
+			}
+		case *seqPattern:
+			for _, item := range n.items {
+				walk(item)
+			}
+		case *anyPattern:
+			for _, item := range n.items {
+				walk(item)
+			}
+		}
+	}
+	walk(p)
+	return out
+}
+
+// flattenPattern walks the EVENT clause AST into compiledPattern's flat step
+// list. Nested SEQs splice their steps in line, marking the first step of
+// each item after the first as contiguous if the SEQ is a SEQ+; nested ANYs
+// (and anything nested inside them) collapse to a single alternation step,
+// since only one of their captures needs to occur to satisfy that position
+// in the pattern.
+func flattenPattern(cp *compiledPattern, p eventPattern) {
+	switch n := p.(type) {
+	case *capturePattern:
+		if n.negated {
+			idx := len(cp.steps)
+			cp.negAfter[idx] = append(cp.negAfter[idx], patternAlt{eventType: n.eventType, alias: n.alias})
+			return
+		}
+		if n.kleene {
+			step := positiveStep{kleene: true, kleeneAlt: patternAlt{eventType: n.eventType, alias: n.alias}}
+			if n.until != nil {
+				until := patternAlt{eventType: n.until.eventType, alias: n.until.alias}
+				step.untilAlt = &until
+			}
+			cp.steps = append(cp.steps, step)
+			return
+		}
+		cp.steps = append(cp.steps, positiveStep{alts: []patternAlt{{eventType: n.eventType, alias: n.alias}}})
+	case *seqPattern:
+		for i, item := range n.items {
+			boundary := len(cp.steps)
+			flattenPattern(cp, item)
+			if n.contiguous && i > 0 {
+				cp.contiguousAt[boundary] = true
+			}
+		}
+	case *anyPattern:
+		cp.steps = append(cp.steps, positiveStep{alts: collectAlts(n)})
+	}
+}
+
+// collectAlts gathers every non-negated capture reachable under p, for use
+// as the alternatives of a single ANY-derived step.
+func collectAlts(p eventPattern) []patternAlt {
+	var out []patternAlt
+	var walk func(eventPattern)
+	walk = func(p eventPattern) {
+		switch n := p.(type) {
+		case *capturePattern:
+			if !n.negated {
+				out = append(out, patternAlt{eventType: n.eventType, alias: n.alias})
+			}
+		case *seqPattern:
+			for _, item := range n.items {
+				walk(item)
+			}
+		case *anyPattern:
+			for _, item := range n.items {
+				walk(item)
+			}
+		}
+	}
+	walk(p)
+	return out
+}
+
+func (s positiveStep) match(ev Event) (patternAlt, bool) {
+	for _, alt := range s.alts {
+		if alt.eventType == ev.Type() {
+			return alt, true
+		}
+	}
+	return patternAlt{}, false
+}
+
+func matchesAny(alts []patternAlt, ev Event) bool {
+	for _, alt := range alts {
+		if alt.eventType == ev.Type() {
+			return true
+		}
+	}
+	return false
+}
+
+// instance is one in-progress partial match of the pattern. listBindings
+// holds the events captured so far by any Kleene-plus steps the pattern has
+// already reached; closedLists marks which of those have finished (their
+// UNTIL terminator has already been seen), so aggregate operands know their
+// value is final rather than still growing. heldMatch is non-nil once every
+// positive step has been satisfied but a trailing negation (one with
+// nothing after it in the pattern) still hasn't had its window to occur;
+// see completeOrHold.
+type instance struct {
+	stepIdx      int
+	bindings     map[string]Event
+	listBindings map[string][]Event
+	closedLists  map[string]bool
+	heldMatch    *Match
+	anchor       time.Time // timestamp of the instance's first bound event
+}
+
+func (in *instance) clone() *instance {
+	bindings := make(map[string]Event, len(in.bindings)+1)
+	for k, v := range in.bindings {
+		bindings[k] = v
+	}
+	listBindings := make(map[string][]Event, len(in.listBindings))
+	for k, v := range in.listBindings {
+		events := make([]Event, len(v))
+		copy(events, v)
+		listBindings[k] = events
+	}
+	closedLists := make(map[string]bool, len(in.closedLists))
+	for k, v := range in.closedLists {
+		closedLists[k] = v
+	}
+	return &instance{stepIdx: in.stepIdx, bindings: bindings, listBindings: listBindings, closedLists: closedLists, anchor: in.anchor}
+}
+
+// Push feeds ev through the matcher's NFA: expiring and invalidating
+// existing partial matches, advancing the ones ev satisfies, starting a new
+// one if ev can begin the pattern, and returning any matches completed as a
+// result. Completed and dropped instances are both removed from the live
+// set; Push is safe for concurrent use.
+func (m *compiledMatcher) Push(ev Event) []Match {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	window := m.query.window
+	now := ev.Timestamp()
+
+	var matches []Match
+
+	live := m.instances[:0:0]
+	for _, inst := range m.instances {
+		if window > 0 && now.Sub(inst.anchor) > window {
+			if inst.heldMatch != nil {
+				// The window closed without the trailing negation firing,
+				// so the match it was holding is now confirmed.
+				matches = append(matches, *inst.heldMatch)
+			}
+			continue
+		}
+		if forbidden, ok := m.pattern.negAfter[inst.stepIdx]; ok && matchesAny(forbidden, ev) {
+			continue
+		}
+		if inst.stepIdx < len(m.pattern.steps) && m.pattern.contiguousAt[inst.stepIdx] &&
+			m.pattern.declaredTypes[ev.Type()] && !m.pattern.steps[inst.stepIdx].matchesEvent(ev) {
+			continue
+		}
+		live = append(live, inst)
+	}
+
+	next := make([]*instance, 0, len(live))
+	for _, inst := range live {
+		advanced, match, ok := m.tryAdvance(inst, ev)
+		if !ok {
+			next = append(next, inst)
+			continue
+		}
+		if match != nil {
+			matches = append(matches, *match)
+			continue
+		}
+		next = append(next, advanced)
+	}
+
+	if len(m.pattern.steps) > 0 {
+		fresh := &instance{stepIdx: 0, bindings: map[string]Event{}, listBindings: map[string][]Event{}, closedLists: map[string]bool{}, anchor: now}
+		if advanced, match, ok := m.tryAdvance(fresh, ev); ok {
+			if match != nil {
+				matches = append(matches, *match)
+			} else {
+				next = append(next, advanced)
+			}
+		}
+	}
+
+	m.instances = next
+
+	if len(matches) > 0 {
+		m.publish(matches)
+	}
+	return matches
+}
+
+// tryAdvance attempts to consume ev as the step inst is currently waiting
+// on. ok is false if ev doesn't satisfy that step, or if it does but the
+// WHERE predicate has already ruled the resulting binding out (both cases
+// mean inst itself should live on unchanged). match is non-nil if consuming
+// ev completed the pattern.
+func (m *compiledMatcher) tryAdvance(inst *instance, ev Event) (advanced *instance, match *Match, ok bool) {
+	if inst.stepIdx >= len(m.pattern.steps) {
+		return nil, nil, false
+	}
+	step := m.pattern.steps[inst.stepIdx]
+	if step.kleene {
+		return m.tryAdvanceKleene(inst, step, ev)
+	}
+
+	alt, matched := step.match(ev)
+	if !matched {
+		return nil, nil, false
+	}
+
+	next := inst.clone()
+	next.bindings[alt.alias] = ev
+	next.stepIdx++
+
+	if !partialPredicateOK(m.query.where, eventLookup(next)) {
+		return nil, nil, false
+	}
+
+	return m.completeOrHold(next)
+}
+
+// tryAdvanceKleene feeds ev to an instance waiting on a Kleene-plus step: if
+// ev matches the UNTIL terminator and at least one event has already been
+// captured, it ends the run, binds the terminator, and advances past the
+// step; if ev matches the repeated type, it extends the run's list capture
+// without advancing; otherwise inst is left unchanged. A kleene step with no
+// untilAlt can only ever extend, never complete, through Push.
+func (m *compiledMatcher) tryAdvanceKleene(inst *instance, step positiveStep, ev Event) (advanced *instance, match *Match, ok bool) {
+	if step.untilAlt != nil && ev.Type() == step.untilAlt.eventType && len(inst.listBindings[step.kleeneAlt.alias]) > 0 {
+		next := inst.clone()
+		next.bindings[step.untilAlt.alias] = ev
+		next.closedLists[step.kleeneAlt.alias] = true
+		next.stepIdx++
+
+		if !partialPredicateOK(m.query.where, eventLookup(next)) {
+			return nil, nil, false
+		}
+		return m.completeOrHold(next)
+	}
+
+	if ev.Type() == step.kleeneAlt.eventType {
+		next := inst.clone()
+		next.listBindings[step.kleeneAlt.alias] = append(next.listBindings[step.kleeneAlt.alias], ev)
+
+		if !partialPredicateOK(m.query.where, eventLookup(next)) {
+			return nil, nil, false
+		}
+		return next, nil, true
+	}
+
+	return nil, nil, false
+}
+
+// completeOrHold decides what happens once next has satisfied every
+// positive step in the pattern: if nothing can still invalidate it, it's
+// returned as a completed Match; if a trailing negation (one with nothing
+// after it in the pattern) is still outstanding, the Match is stashed on
+// next as heldMatch instead, and next keeps living in m.instances so Push's
+// negAfter/window-expiry checks keep applying to it until the negation's
+// window has definitely passed. Query.Compile rejects any pattern with a
+// trailing negation and no WITHIN clause, so that window is guaranteed to
+// close eventually.
+func (m *compiledMatcher) completeOrHold(next *instance) (advanced *instance, match *Match, ok bool) {
+	if next.stepIdx != len(m.pattern.steps) {
+		return next, nil, true
+	}
+	result := &Match{Captures: next.bindings, ListCaptures: next.listBindings}
+	if len(m.pattern.negAfter[next.stepIdx]) == 0 {
+		return nil, result, true
+	}
+	next.heldMatch = result
+	return next, nil, true
+}
+
+func eventLookup(inst *instance) attrLookup {
+	return attrLookup{
+		attr: func(alias, attr string) (any, bool) {
+			ev, ok := inst.bindings[alias]
+			if !ok {
+				return nil, false
+			}
+			return ev.Attr(attr)
+		},
+		list: func(alias string) ([]Event, bool) {
+			events, ok := inst.listBindings[alias]
+			return events, ok
+		},
+		closed: func(alias string) bool { return inst.closedLists[alias] },
+	}
+}
+
+// partialPredicateOK reports whether a partial match, with only some of its
+// captures bound so far, can still possibly satisfy the WHERE predicate. A
+// sub-expression referencing a capture that hasn't happened yet can't be
+// judged either way, so it must not disqualify the instance; only a
+// sub-expression that already evaluates definitely false does.
+func partialPredicateOK(p predicate, lookup attrLookup) bool {
+	if p == nil {
+		return true
+	}
+	result := partialEval(p, lookup)
+	return result == nil || *result
+}
+
+// partialEval is a three-valued (true / false / unknown) evaluator: nil
+// means "can't tell yet because some referenced capture isn't bound".
+func partialEval(p predicate, lookup attrLookup) *bool {
+	switch n := p.(type) {
+	case *comparison:
+		lv, lok := resolveOperand(lookup, n.lhs)
+		rv, rok := resolveOperand(lookup, n.rhs)
+		if !lok || !rok {
+			return nil
+		}
+		return boolPtr(compareValues(lv, n.op, rv))
+
+	case *andPredicate:
+		sawUnknown := false
+		for _, c := range n.clauses {
+			switch r := partialEval(c, lookup); {
+			case r == nil:
+				sawUnknown = true
+			case !*r:
+				return boolPtr(false)
+			}
+		}
+		if sawUnknown {
+			return nil
+		}
+		return boolPtr(true)
+
+	case *orPredicate:
+		sawUnknown := false
+		for _, c := range n.clauses {
+			switch r := partialEval(c, lookup); {
+			case r == nil:
+				sawUnknown = true
+			case *r:
+				return boolPtr(true)
+			}
+		}
+		if sawUnknown {
+			return nil
+		}
+		return boolPtr(false)
+
+	case *notPredicate:
+		r := partialEval(n.inner, lookup)
+		if r == nil {
+			return nil
+		}
+		return boolPtr(!*r)
+
+	case *likePredicate:
+		return partialFieldEval(n.field, lookup, func(v any) bool {
+			s, ok := v.(string)
+			return ok && n.re.MatchString(s)
+		})
+
+	case *regexPredicate:
+		return partialFieldEval(n.field, lookup, func(v any) bool {
+			s, ok := v.(string)
+			return ok && n.re.MatchString(s)
+		})
+
+	case *inPredicate:
+		values := n.values
+		return partialFieldEval(n.field, lookup, func(v any) bool {
+			for _, lit := range values {
+				if literalEquals(lit, v) {
+					return true
+				}
+			}
+			return false
+		})
+
+	default:
+		return nil
+	}
+}
+
+func partialFieldEval(field operand, lookup attrLookup, pred func(any) bool) *bool {
+	v, ok := lookup.attr(field.alias, field.attr)
+	if !ok {
+		return nil
+	}
+	return boolPtr(pred(v))
+}
+
+func boolPtr(b bool) *bool { return &b }
@@ -0,0 +1,718 @@
+// Package query implements the SASE complex-event-processing query
+// language: parsing query text into a Query, and compiling a Query into a
+// Matcher that can be run against event streams.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseError is returned by Parse when query text is malformed. Pos is the
+// byte offset into the original text where the problem was detected.
+type ParseError struct {
+	Pos int
+	msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("query: %s (at offset %d)", e.msg, e.Pos)
+}
+
+// Query is a parsed SASE query. Queries are immutable once returned from
+// Parse; call Compile to obtain a Matcher that can be run against events.
+type Query struct {
+	pattern      eventPattern
+	where        predicate // nil if no WHERE clause
+	window       time.Duration
+	windowPos    int               // byte offset of the WITHIN duration token, if window > 0
+	captures     map[string]string // alias -> event type
+	captureOrder []string          // aliases in declaration order, for stable annotation output
+	listCaptures map[string]bool   // subset of captures bound by a Kleene-plus ("+") capture
+	annotations  []Annotation
+}
+
+// Annotations returns the non-fatal issues noticed while parsing the query,
+// such as a WITHIN window too small to ever match or a capture that's never
+// used. They don't affect the query's validity; Parse still returns a nil
+// error for any query that produced only annotations.
+func (q *Query) Annotations() []Annotation {
+	out := make([]Annotation, len(q.annotations))
+	copy(out, q.annotations)
+	return out
+}
+
+// Window returns the duration of the query's WITHIN clause, or zero if the
+// query has none.
+func (q *Query) Window() time.Duration { return q.window }
+
+// Captures returns the alias -> event-type mapping declared by the query's
+// EVENT clause. This includes Kleene-plus (list) captures; use ListCaptures
+// to tell those apart from scalar ones.
+func (q *Query) Captures() map[string]string {
+	out := make(map[string]string, len(q.captures))
+	for k, v := range q.captures {
+		out[k] = v
+	}
+	return out
+}
+
+// ListCaptures returns the alias -> event-type mapping of this query's
+// Kleene-plus captures ("t e+") only: the subset of Captures() that bind a
+// slice of events instead of a single one, and so must be read in WHERE via
+// indexing ("e[i].attr") or an aggregate function rather than "e.attr".
+func (q *Query) ListCaptures() map[string]string {
+	out := make(map[string]string, len(q.listCaptures))
+	for alias := range q.listCaptures {
+		out[alias] = q.captures[alias]
+	}
+	return out
+}
+
+// parser holds the state needed to walk a token stream once.
+type parser struct {
+	toks         []token
+	pos          int
+	captures     map[string]string
+	captureOrder []string
+	listCaptures map[string]bool
+}
+
+// Parse parses SASE query text into a Query. An optional trailing ';' (with
+// surrounding whitespace) is permitted.
+func Parse(queryText string) (*Query, error) {
+	lx := newLexer(queryText)
+	defer lx.release()
+	toks, err := lx.scan()
+	if err != nil {
+		return nil, err
+	}
+	// toks is owned by the lexer's pooled scratch buffer; copy it out so it
+	// survives past release().
+	owned := make([]token, len(toks))
+	copy(owned, toks)
+
+	p := &parser{toks: owned, captures: map[string]string{}}
+	q, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	q.annotations = annotate(q, queryText)
+	return q, nil
+}
+
+// ParseWithAnnotations is like Parse, but also returns the non-fatal
+// annotations noticed along the way. Parse(q.QueryText()) callers that
+// don't care about them can keep ignoring the second return value; q's own
+// Annotations() method returns the same slice later.
+func ParseWithAnnotations(queryText string) (*Query, []Annotation, error) {
+	q, err := Parse(queryText)
+	if err != nil {
+		return nil, nil, err
+	}
+	return q, q.Annotations(), nil
+}
+
+// MustParse is like Parse but panics on error. Intended for package-level
+// query literals where a parse failure is a programmer error.
+func MustParse(queryText string) *Query {
+	q, err := Parse(queryText)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+func (p *parser) advance() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.peek()
+	if t.kind != kind {
+		return token{}, p.errorf(t, "expected %s", what)
+	}
+	return p.advance(), nil
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &ParseError{Pos: t.pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parseQuery() (*Query, error) {
+	if _, err := p.expect(tokKeywordEvent, "'EVENT'"); err != nil {
+		return nil, err
+	}
+	pattern, err := p.parseEventClause()
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{pattern: pattern, captures: p.captures, captureOrder: p.captureOrder, listCaptures: p.listCaptures}
+
+	if p.peek().kind == tokKeywordWhere {
+		p.advance()
+		where, err := p.parseWhereClause()
+		if err != nil {
+			return nil, err
+		}
+		q.where = where
+	}
+
+	if p.peek().kind == tokKeywordWithin {
+		p.advance()
+		window, windowPos, err := p.parseWithinClause()
+		if err != nil {
+			return nil, err
+		}
+		q.window = window
+		q.windowPos = windowPos
+	}
+
+	if p.peek().kind == tokSemicolon {
+		p.advance()
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf(p.peek(), "unexpected trailing input %q", p.peek().lexeme)
+	}
+
+	return q, nil
+}
+
+// parseEventClause parses the pattern following EVENT: either a single bare
+// capture, or a SEQ(...)/ANY(...) grouping.
+func (p *parser) parseEventClause() (eventPattern, error) {
+	switch p.peek().kind {
+	case tokKeywordSeq, tokKeywordAny:
+		return p.parseGroup()
+	case tokIdent:
+		cap, err := p.parseCapture()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind == tokComma {
+			return nil, p.errorf(p.peek(), "multiple captures at the top level must be wrapped in SEQ(...) or ANY(...)")
+		}
+		return cap, nil
+	default:
+		return nil, p.errorf(p.peek(), "expected a capture or SEQ/ANY after EVENT")
+	}
+}
+
+// parseGroup parses "SEQ(", "SEQ+(", or "ANY(" followed by a comma-separated,
+// non-empty list of pattern items and a closing ")". "SEQ+" additionally
+// requires its items to occur back-to-back, with no intervening event of any
+// type declared elsewhere in the query.
+func (p *parser) parseGroup() (eventPattern, error) {
+	isSeq := p.peek().kind == tokKeywordSeq
+	p.advance() // SEQ or ANY
+
+	contiguous := false
+	if isSeq && p.peek().kind == tokPlus {
+		p.advance()
+		contiguous = true
+	}
+
+	if _, err := p.expect(tokLParen, "'('"); err != nil {
+		return nil, err
+	}
+
+	var items []eventPattern
+	for {
+		item, err := p.parseGroupItem()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.peek().kind == tokComma {
+			p.advance()
+			continue
+		}
+		break
+	}
+
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, p.errorf(p.peek(), "SEQ/ANY must contain at least one capture")
+	}
+
+	if isSeq {
+		return &seqPattern{items: items, contiguous: contiguous}, nil
+	}
+	return &anyPattern{items: items}, nil
+}
+
+// parseGroupItem parses one element of a SEQ/ANY list: a capture, a negated
+// capture "!(t e)", or a nested SEQ/ANY group.
+func (p *parser) parseGroupItem() (eventPattern, error) {
+	switch p.peek().kind {
+	case tokKeywordSeq, tokKeywordAny:
+		return p.parseGroup()
+	case tokBang:
+		p.advance()
+		if _, err := p.expect(tokLParen, "'(' after '!'"); err != nil {
+			return nil, err
+		}
+		cap, err := p.parseCapture()
+		if err != nil {
+			return nil, err
+		}
+		if cap.kleene {
+			return nil, p.errorf(p.peek(), "a Kleene-plus capture (%q+) cannot be negated", cap.alias)
+		}
+		cap.negated = true
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return cap, nil
+	case tokIdent:
+		return p.parseCapture()
+	default:
+		return nil, p.errorf(p.peek(), "expected a capture, '!(...)', or SEQ/ANY")
+	}
+}
+
+// parseCapture parses "<type> <alias>" and registers the alias. A trailing
+// "+" ("<type> <alias>+") makes it a Kleene-plus capture binding a list
+// instead of a single event; such a capture may optionally be followed by
+// "UNTIL (<type> <alias>)", whose own capture is parsed (and registered) via
+// a nested call to parseCapture and terminates the Kleene-plus run.
+func (p *parser) parseCapture() (*capturePattern, error) {
+	typeTok, err := p.expect(tokIdent, "an event type")
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokIdent {
+		return nil, p.errorf(p.peek(), "expected an alias after event type %q", typeTok.lexeme)
+	}
+	aliasTok := p.advance()
+
+	if _, clash := p.captures[aliasTok.lexeme]; clash {
+		return nil, p.errorf(aliasTok, "capture alias %q is already in use", aliasTok.lexeme)
+	}
+
+	kleene := false
+	if p.peek().kind == tokPlus {
+		p.advance()
+		kleene = true
+	}
+
+	p.captures[aliasTok.lexeme] = typeTok.lexeme
+	p.captureOrder = append(p.captureOrder, aliasTok.lexeme)
+	if kleene {
+		if p.listCaptures == nil {
+			p.listCaptures = map[string]bool{}
+		}
+		p.listCaptures[aliasTok.lexeme] = true
+	}
+
+	cap := &capturePattern{eventType: typeTok.lexeme, alias: aliasTok.lexeme, aliasPos: aliasTok.pos, kleene: kleene}
+
+	if kleene && p.peek().kind == tokKeywordUntil {
+		p.advance()
+		if _, err := p.expect(tokLParen, "'(' after UNTIL"); err != nil {
+			return nil, err
+		}
+		until, err := p.parseCapture()
+		if err != nil {
+			return nil, err
+		}
+		if until.kleene {
+			return nil, p.errorf(p.peek(), "UNTIL terminator %q cannot itself be a Kleene-plus capture", until.alias)
+		}
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		cap.until = until
+	}
+
+	return cap, nil
+}
+
+// parseWhereClause parses the WHERE predicate, which is an OR of ANDs of
+// unary (NOT / parenthesized / atomic) terms — standard SQL precedence,
+// OR binding loosest and NOT tightest.
+func (p *parser) parseWhereClause() (predicate, error) {
+	return p.parseOrExpr()
+}
+
+func (p *parser) parseOrExpr() (predicate, error) {
+	first, err := p.parseAndExpr()
+	if err != nil {
+		return nil, err
+	}
+	clauses := []predicate{first}
+	for p.peek().kind == tokKeywordOr {
+		p.advance()
+		next, err := p.parseAndExpr()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, next)
+	}
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return &orPredicate{clauses: clauses}, nil
+}
+
+func (p *parser) parseAndExpr() (predicate, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	clauses := []predicate{first}
+	for p.peek().kind == tokKeywordAnd {
+		p.advance()
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, next)
+	}
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return &andPredicate{clauses: clauses}, nil
+}
+
+func (p *parser) parseUnary() (predicate, error) {
+	if p.peek().kind == tokKeywordNot {
+		p.advance()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notPredicate{inner: inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a parenthesized sub-expression or a single atomic
+// term (comparison, LIKE, MATCHES, or IN).
+func (p *parser) parsePrimary() (predicate, error) {
+	if p.peek().kind == tokLParen {
+		p.advance()
+		inner, err := p.parseOrExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	return p.parseAtom()
+}
+
+// parseAtom parses a field, indexed, or aggregate operand, followed by a
+// comparison operator, LIKE, MATCHES, or IN.
+func (p *parser) parseAtom() (predicate, error) {
+	field, err := p.parseFieldOrAggOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.peek().kind {
+	case tokKeywordLike:
+		if err := requirePlainField(field); err != nil {
+			return nil, p.errorf(p.peek(), "%s", err)
+		}
+		p.advance()
+		t, err := p.expect(tokString, "a LIKE pattern")
+		if err != nil {
+			return nil, err
+		}
+		re, rerr := likePatternToRegexp(t.lexeme)
+		if rerr != nil {
+			return nil, p.errorf(t, "invalid LIKE pattern %q: %s", t.lexeme, rerr)
+		}
+		return &likePredicate{field: field, pattern: t.lexeme, re: re}, nil
+
+	case tokKeywordMatches:
+		if err := requirePlainField(field); err != nil {
+			return nil, p.errorf(p.peek(), "%s", err)
+		}
+		p.advance()
+		t, err := p.expect(tokRegex, "a /regex/ literal")
+		if err != nil {
+			return nil, err
+		}
+		re, rerr := regexp.Compile(t.lexeme)
+		if rerr != nil {
+			return nil, p.errorf(t, "invalid regular expression %q: %s", t.lexeme, rerr)
+		}
+		return &regexPredicate{field: field, pattern: t.lexeme, re: re}, nil
+
+	case tokKeywordIn:
+		if err := requirePlainField(field); err != nil {
+			return nil, p.errorf(p.peek(), "%s", err)
+		}
+		p.advance()
+		if _, err := p.expect(tokLParen, "'(' after IN"); err != nil {
+			return nil, err
+		}
+		var values []operand
+		for {
+			v, err := p.parseLiteralOperand()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			if p.peek().kind == tokComma {
+				p.advance()
+				continue
+			}
+			break
+		}
+		if _, err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return &inPredicate{field: field, values: values}, nil
+
+	default:
+		op, err := p.parseCompareOp()
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &comparison{lhs: field, op: op, rhs: rhs}, nil
+	}
+}
+
+// likePatternToRegexp translates a LIKE pattern (SQL wildcards '%' and '_')
+// into an equivalent, fully anchored regexp.
+func likePatternToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// parseLiteralOperand parses a string or number literal, for use in IN (...).
+func (p *parser) parseLiteralOperand() (operand, error) {
+	switch p.peek().kind {
+	case tokString:
+		t := p.advance()
+		return operand{isString: true, str: t.lexeme, pos: t.pos}, nil
+	case tokNumber:
+		t := p.advance()
+		n, err := strconv.ParseFloat(t.lexeme, 64)
+		if err != nil {
+			return operand{}, p.errorf(t, "invalid numeric literal %q", t.lexeme)
+		}
+		return operand{isNumber: true, num: n, pos: t.pos}, nil
+	default:
+		return operand{}, p.errorf(p.peek(), "expected a literal value in IN (...)")
+	}
+}
+
+// parseFieldOperand parses "<alias>.<attr>" or, for a Kleene-plus list
+// capture, the indexed form "<alias>[<n>].<attr>"; either way it validates
+// that alias was declared by the EVENT clause.
+func (p *parser) parseFieldOperand() (operand, error) {
+	aliasTok, err := p.expect(tokIdent, "a capture alias")
+	if err != nil {
+		return operand{}, err
+	}
+	if _, ok := p.captures[aliasTok.lexeme]; !ok {
+		return operand{}, p.errorf(aliasTok, "%q is not a declared capture alias", aliasTok.lexeme)
+	}
+
+	var index *int
+	if p.peek().kind == tokLBracket {
+		if !p.listCaptures[aliasTok.lexeme] {
+			return operand{}, p.errorf(p.peek(), "%q is not a Kleene-plus capture; only those support indexing", aliasTok.lexeme)
+		}
+		p.advance()
+		idxTok, err := p.expect(tokNumber, "an index")
+		if err != nil {
+			return operand{}, err
+		}
+		n, err := strconv.Atoi(idxTok.lexeme)
+		if err != nil || n < 0 {
+			return operand{}, p.errorf(idxTok, "invalid list index %q", idxTok.lexeme)
+		}
+		if _, err := p.expect(tokRBracket, "']'"); err != nil {
+			return operand{}, err
+		}
+		index = &n
+	} else if p.listCaptures[aliasTok.lexeme] {
+		return operand{}, p.errorf(p.peek(), "%q is a Kleene-plus capture; use %q[i] or an aggregate function", aliasTok.lexeme, aliasTok.lexeme)
+	}
+
+	if _, err := p.expect(tokDot, "'.'"); err != nil {
+		return operand{}, err
+	}
+	attrTok, err := p.expect(tokIdent, "an attribute name")
+	if err != nil {
+		return operand{}, err
+	}
+	return operand{alias: aliasTok.lexeme, attr: attrTok.lexeme, index: index, pos: aliasTok.pos}, nil
+}
+
+// parseAggregateOperand parses "COUNT(<alias>)", "SUM(<alias>.<attr>)",
+// "AVG(<alias>.<attr>)", "FIRST(<alias>.<attr>)", or "LAST(<alias>.<attr>)",
+// validating that alias is a declared Kleene-plus (list) capture.
+func (p *parser) parseAggregateOperand() (operand, error) {
+	t := p.advance() // COUNT / SUM / AVG / FIRST / LAST
+	var agg aggFunc
+	switch t.kind {
+	case tokKeywordCount:
+		agg = aggCount
+	case tokKeywordSum:
+		agg = aggSum
+	case tokKeywordAvg:
+		agg = aggAvg
+	case tokKeywordFirst:
+		agg = aggFirst
+	case tokKeywordLast:
+		agg = aggLast
+	}
+
+	if _, err := p.expect(tokLParen, "'(' after "+t.lexeme); err != nil {
+		return operand{}, err
+	}
+	aliasTok, err := p.expect(tokIdent, "a capture alias")
+	if err != nil {
+		return operand{}, err
+	}
+	if _, ok := p.captures[aliasTok.lexeme]; !ok {
+		return operand{}, p.errorf(aliasTok, "%q is not a declared capture alias", aliasTok.lexeme)
+	}
+	if !p.listCaptures[aliasTok.lexeme] {
+		return operand{}, p.errorf(aliasTok, "%s(...) requires a Kleene-plus capture, but %q is a scalar capture", t.lexeme, aliasTok.lexeme)
+	}
+
+	var attr string
+	if agg != aggCount {
+		if _, err := p.expect(tokDot, "'.'"); err != nil {
+			return operand{}, err
+		}
+		attrTok, err := p.expect(tokIdent, "an attribute name")
+		if err != nil {
+			return operand{}, err
+		}
+		attr = attrTok.lexeme
+	}
+
+	if _, err := p.expect(tokRParen, "')'"); err != nil {
+		return operand{}, err
+	}
+	return operand{alias: aliasTok.lexeme, attr: attr, agg: agg, pos: t.pos}, nil
+}
+
+// parseFieldOrAggOperand parses whichever of a field/indexed operand or an
+// aggregate function starts the upcoming tokens; used for the left-hand side
+// of a WHERE atom, where either may appear.
+func (p *parser) parseFieldOrAggOperand() (operand, error) {
+	switch p.peek().kind {
+	case tokKeywordCount, tokKeywordSum, tokKeywordAvg, tokKeywordFirst, tokKeywordLast:
+		return p.parseAggregateOperand()
+	default:
+		return p.parseFieldOperand()
+	}
+}
+
+// requirePlainField rejects an aggregate or indexed operand for LIKE,
+// MATCHES, and IN, which only know how to test a single scalar value.
+func requirePlainField(o operand) error {
+	if o.agg != aggNone {
+		return fmt.Errorf("%s(...) cannot be used with LIKE, MATCHES, or IN", o.agg)
+	}
+	if o.index != nil {
+		return fmt.Errorf("an indexed capture cannot be used with LIKE, MATCHES, or IN")
+	}
+	return nil
+}
+
+// parseOperand parses the right-hand side of a comparison: a field,
+// indexed, or aggregate operand, or a string/number literal.
+func (p *parser) parseOperand() (operand, error) {
+	switch p.peek().kind {
+	case tokIdent:
+		return p.parseFieldOperand()
+	case tokKeywordCount, tokKeywordSum, tokKeywordAvg, tokKeywordFirst, tokKeywordLast:
+		return p.parseAggregateOperand()
+	case tokString:
+		t := p.advance()
+		return operand{isString: true, str: t.lexeme, pos: t.pos}, nil
+	case tokNumber:
+		t := p.advance()
+		n, err := strconv.ParseFloat(t.lexeme, 64)
+		if err != nil {
+			return operand{}, p.errorf(t, "invalid numeric literal %q", t.lexeme)
+		}
+		return operand{isNumber: true, num: n, pos: t.pos}, nil
+	default:
+		return operand{}, p.errorf(p.peek(), "expected a capture field or a literal")
+	}
+}
+
+func (p *parser) parseCompareOp() (compareOp, error) {
+	t := p.peek()
+	var op compareOp
+	switch t.kind {
+	case tokEq:
+		op = opEq
+	case tokNeq:
+		op = opNeq
+	case tokLt:
+		op = opLt
+	case tokLte:
+		op = opLte
+	case tokGt:
+		op = opGt
+	case tokGte:
+		op = opGte
+	default:
+		return 0, p.errorf(t, "expected a comparison operator")
+	}
+	p.advance()
+	return op, nil
+}
+
+// parseWithinClause parses the duration literal following WITHIN and
+// validates it, returning the duration and the byte offset it was found at.
+func (p *parser) parseWithinClause() (time.Duration, int, error) {
+	t, err := p.expect(tokDuration, "a duration")
+	if err != nil {
+		// A bare number (e.g. "WITHIN 4") is a duration missing its unit;
+		// give a more specific message than "expected a duration".
+		if p.peek().kind == tokNumber {
+			return 0, 0, p.errorf(p.peek(), "duration %q is missing a unit (e.g. 'h', 'm', 's')", p.peek().lexeme)
+		}
+		return 0, 0, err
+	}
+	d, err := time.ParseDuration(t.lexeme)
+	if err != nil {
+		return 0, 0, p.errorf(t, "invalid duration %q: %s", t.lexeme, err)
+	}
+	if d <= 0 {
+		return 0, 0, p.errorf(t, "WITHIN duration must be positive, got %q", t.lexeme)
+	}
+	return d, t.pos, nil
+}
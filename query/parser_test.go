@@ -54,6 +54,23 @@ func TestParsing(t *testing.T) {
 		"EVENT a b WHERE a.foo == \"bar\"": false, // Nonexistant event
 		"EVENT a b WHERE b.foo == a.bar":   false, // Nonexistant event
 
+		// EVENT + Kleene-plus / UNTIL / SEQ+
+		"EVENT SEQ+(a e1, b e2)":                                                                true,
+		"EVENT SEQ+(a e1, b e2, c e3)":                                                          true,
+		"EVENT SEQ(t1 e1, t2 e2+)":                                                              true,
+		"EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3))":                                                true,
+		"EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE COUNT(e2) >= 1":                           true,
+		"EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE SUM(e2.n) > 0":                            true,
+		"EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE AVG(e2.n) > 0":                            true,
+		"EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE FIRST(e2.n) == 1.0 AND LAST(e2.n) == 2.0": true,
+		"EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE e2[0].n == 1.0":                           true,
+		// Errors
+		"EVENT SEQ(t1 e1, !(t2 e2+))":                              false, // Kleene capture cannot be negated
+		"EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3+))":                  false, // UNTIL terminator cannot itself be Kleene
+		"EVENT SEQ(t1 e1, t2 e2) WHERE COUNT(e2) >= 1":             false, // COUNT over a scalar capture
+		"EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3)) WHERE e2.n == 1.0": false, // scalar read of a Kleene capture
+		"EVENT SEQ(t1 e1, t2 e2) WHERE e1[0].n == 1.0":             false, // indexing a scalar capture
+
 		// EVENT + WITHIN
 		"EVENT a b WITHIN 1h":                                  true,
 		"EVENT SEQ(a b) WITHIN 30m":                            true,
@@ -123,6 +140,16 @@ func TestParsingCaptureNames(t *testing.T) {
 	}
 }
 
+func TestParsingListCaptures(t *testing.T) {
+	q, err := Parse("EVENT SEQ(t1 e1, t2 e2+ UNTIL (t3 e3))")
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{"e1": "t1", "e2": "t2", "e3": "t3"}, q.Captures(),
+		"Captures() should include the Kleene-plus capture and its UNTIL terminator")
+	require.Equal(t, map[string]string{"e2": "t2"}, q.ListCaptures(),
+		"ListCaptures() should contain only the Kleene-plus capture")
+}
+
 func TestParsingWindow(t *testing.T) {
 	expectations := map[string]time.Duration{
 		"1m":    time.Minute,
@@ -0,0 +1,188 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+)
+
+// QueryText renders the Query back into SASE query text. The output is
+// canonical: re-parsing it always yields the same canonical text again,
+// even if the original input used different (but equivalent) formatting.
+func (q *Query) QueryText() string {
+	var b strings.Builder
+	b.WriteString("EVENT ")
+	writePattern(&b, q.pattern)
+
+	if q.where != nil {
+		b.WriteString(" WHERE ")
+		writePredicate(&b, q.where)
+	}
+
+	if q.window > 0 {
+		b.WriteString(" WITHIN ")
+		b.WriteString(q.window.String())
+	}
+
+	return b.String()
+}
+
+func writePattern(b *strings.Builder, p eventPattern) {
+	switch n := p.(type) {
+	case *capturePattern:
+		if n.negated {
+			b.WriteString("!(")
+			writeCapture(b, n)
+			b.WriteString(")")
+			return
+		}
+		writeCapture(b, n)
+	case *seqPattern:
+		b.WriteString("SEQ")
+		if n.contiguous {
+			b.WriteString("+")
+		}
+		b.WriteString("(")
+		writePatternList(b, n.items)
+		b.WriteString(")")
+	case *anyPattern:
+		b.WriteString("ANY(")
+		writePatternList(b, n.items)
+		b.WriteString(")")
+	}
+}
+
+func writeCapture(b *strings.Builder, c *capturePattern) {
+	b.WriteString(c.eventType)
+	b.WriteString(" ")
+	b.WriteString(c.alias)
+	if c.kleene {
+		b.WriteString("+")
+	}
+	if c.until != nil {
+		b.WriteString(" UNTIL (")
+		writeCapture(b, c.until)
+		b.WriteString(")")
+	}
+}
+
+func writePatternList(b *strings.Builder, items []eventPattern) {
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writePattern(b, item)
+	}
+}
+
+func writePredicate(b *strings.Builder, pr predicate) {
+	switch n := pr.(type) {
+	case *comparison:
+		writeComparison(b, n)
+	case *andPredicate:
+		for i, c := range n.clauses {
+			if i > 0 {
+				b.WriteString(" AND ")
+			}
+			writeAndOperand(b, c)
+		}
+	case *orPredicate:
+		for i, c := range n.clauses {
+			if i > 0 {
+				b.WriteString(" OR ")
+			}
+			// Everything that can appear here (AND, NOT, and atomic terms)
+			// binds at least as tightly as OR, so no parens are needed.
+			writePredicate(b, c)
+		}
+	case *notPredicate:
+		b.WriteString("NOT ")
+		writeNotOperand(b, n.inner)
+	case *likePredicate:
+		writeOperand(b, n.field)
+		b.WriteString(" LIKE '")
+		b.WriteString(n.pattern)
+		b.WriteString("'")
+	case *regexPredicate:
+		writeOperand(b, n.field)
+		b.WriteString(" MATCHES /")
+		b.WriteString(n.pattern)
+		b.WriteString("/")
+	case *inPredicate:
+		writeOperand(b, n.field)
+		b.WriteString(" IN (")
+		for i, v := range n.values {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeOperand(b, v)
+		}
+		b.WriteString(")")
+	}
+}
+
+// writeAndOperand writes one operand of an AND. OR binds looser than AND,
+// so an OR appearing here must be parenthesized to canonicalize back to the
+// same tree on re-parsing.
+func writeAndOperand(b *strings.Builder, p predicate) {
+	if _, ok := p.(*orPredicate); ok {
+		b.WriteString("(")
+		writePredicate(b, p)
+		b.WriteString(")")
+		return
+	}
+	writePredicate(b, p)
+}
+
+// writeNotOperand writes NOT's operand, parenthesizing it if it's a
+// compound (AND/OR) expression so "NOT (a AND b)" doesn't canonicalize into
+// the very different "NOT a AND b".
+func writeNotOperand(b *strings.Builder, p predicate) {
+	switch p.(type) {
+	case *andPredicate, *orPredicate:
+		b.WriteString("(")
+		writePredicate(b, p)
+		b.WriteString(")")
+	default:
+		writePredicate(b, p)
+	}
+}
+
+func writeComparison(b *strings.Builder, c *comparison) {
+	writeOperand(b, c.lhs)
+	b.WriteString(" ")
+	b.WriteString(c.op.String())
+	b.WriteString(" ")
+	writeOperand(b, c.rhs)
+}
+
+func writeOperand(b *strings.Builder, o operand) {
+	if o.agg != aggNone {
+		b.WriteString(o.agg.String())
+		b.WriteString("(")
+		b.WriteString(o.alias)
+		if o.attr != "" {
+			b.WriteString(".")
+			b.WriteString(o.attr)
+		}
+		b.WriteString(")")
+		return
+	}
+	if !o.isLiteral() {
+		b.WriteString(o.alias)
+		if o.index != nil {
+			b.WriteString("[")
+			b.WriteString(strconv.Itoa(*o.index))
+			b.WriteString("]")
+		}
+		b.WriteString(".")
+		b.WriteString(o.attr)
+		return
+	}
+	if o.isString {
+		b.WriteString("'")
+		b.WriteString(o.str)
+		b.WriteString("'")
+		return
+	}
+	b.WriteString(strconv.FormatFloat(o.num, 'g', -1, 64))
+}
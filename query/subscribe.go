@@ -0,0 +1,68 @@
+package query
+
+import (
+	"context"
+	"sync"
+)
+
+// subscriberBuffer bounds how many unconsumed matches a subscriber channel
+// holds before Push starts dropping matches for that subscriber rather than
+// blocking. A slow subscriber should never be able to stall Push, or any
+// other subscriber.
+const subscriberBuffer = 64
+
+type subscriber struct {
+	ch       chan Match
+	done     chan struct{} // closed by cancel, so the ctx-watcher goroutine can exit without ctx ever firing
+	cancelMu sync.Once
+}
+
+// Subscribe registers ch to receive every Match produced by future Push
+// calls. Multiple subscribers can be attached to the same compiled query;
+// each gets its own buffered channel, so one falling behind doesn't affect
+// the others or Push itself. The channel is closed once ctx is done or the
+// returned CancelFunc runs.
+func (m *compiledMatcher) Subscribe(ctx context.Context) (<-chan Match, CancelFunc) {
+	sub := &subscriber{ch: make(chan Match, subscriberBuffer), done: make(chan struct{})}
+	cancel := func() {
+		sub.cancelMu.Do(func() {
+			m.mu.Lock()
+			for i, s := range m.subs {
+				if s == sub {
+					m.subs = append(m.subs[:i], m.subs[i+1:]...)
+					break
+				}
+			}
+			m.mu.Unlock()
+			close(sub.done)
+			close(sub.ch)
+		})
+	}
+
+	m.mu.Lock()
+	m.subs = append(m.subs, sub)
+	m.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-sub.done:
+		}
+	}()
+
+	return sub.ch, cancel
+}
+
+// publish fans matches out to every subscriber without blocking Push: a
+// subscriber whose buffer is full simply misses the match.
+func (m *compiledMatcher) publish(matches []Match) {
+	for _, sub := range m.subs {
+		for _, match := range matches {
+			select {
+			case sub.ch <- match:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsingWhereGrammar(t *testing.T) {
+	expectations := map[string]bool{ // query: expect success parsing?
+		// OR, AND, and precedence between them
+		"EVENT a b WHERE b.foo == 'x' OR b.foo == 'y'":                  true,
+		"EVENT a b WHERE b.foo == 'x' AND b.n == 1.0 OR b.foo == 'y'":   true,
+		"EVENT a b WHERE b.foo == 'x' OR b.n == 1.0 AND b.foo == 'y'":   true,
+		"EVENT a b WHERE (b.foo == 'x' OR b.n == 1.0) AND b.foo == 'y'": true,
+		"EVENT a b WHERE ((b.foo == 'x'))":                              true,
+		// NOT
+		"EVENT a b WHERE NOT b.foo == 'x'":                  true,
+		"EVENT a b WHERE NOT (b.foo == 'x' AND b.n == 1.0)": true,
+		"EVENT a b WHERE NOT NOT b.foo == 'x'":              true,
+		// LIKE
+		"EVENT a b WHERE b.foo LIKE 'x%'":  true,
+		"EVENT a b WHERE b.foo LIKE 'x_y'": true,
+		"EVENT a b WHERE b.foo LIKE 1.0":   false, // LIKE needs a string literal
+		// MATCHES
+		"EVENT a b WHERE b.foo MATCHES /^x.*$/": true,
+		"EVENT a b WHERE b.foo MATCHES /(/":     false, // invalid regex fails parsing
+		// IN
+		"EVENT a b WHERE b.foo IN ('x', 'y', 'z')": true,
+		"EVENT a b WHERE b.n IN (1.0, 2.0)":        true,
+		"EVENT a b WHERE b.foo IN ()":              false, // IN needs at least one value
+		// Errors
+		"EVENT a b WHERE (b.foo == 'x'":      false, // unterminated group
+		"EVENT a b WHERE b.foo == 'x') ":     false, // unbalanced close paren
+		"EVENT a b WHERE (a.foo == 'x')":     false, // undeclared alias inside a group
+		"EVENT a b WHERE NOT (a.foo == 'x')": false, // undeclared alias under NOT
+	}
+
+	te := func(queryText string, expectSuccess bool) {
+		require.NotPanics(t, func() {
+			q, err := Parse(queryText)
+			if expectSuccess {
+				require.NoError(t, err, fmt.Sprintf("Unexpected error parsing \"%s\"", queryText))
+				require.NotNil(t, q, "Query unexpectedly nil for \"%s\"", queryText)
+
+				output := q.QueryText()
+				q2, err := Parse(output)
+				require.NoError(t, err, fmt.Sprintf("Unexpected error parsing generated output \"%s\" (original: \"%s\")",
+					output, queryText))
+				require.Equal(t, output, q2.QueryText(), fmt.Sprintf("Generated outputs do not match for input \"%s\"",
+					queryText))
+			} else {
+				require.Error(t, err, fmt.Sprintf("Error expected parsing \"%s\"", queryText))
+				require.Nil(t, q, "Query unexpectedly not-nil for \"%s\"", queryText)
+			}
+		}, fmt.Sprintf("Unexpected panic parsing \"%s\"", queryText))
+	}
+
+	for queryText, expectSuccess := range expectations {
+		te(queryText, expectSuccess)
+		te(queryText+";", expectSuccess)
+	}
+}
+
+// TestParsingWherePrecedence pins down the exact tree AND/OR precedence
+// produces, not just whether parsing succeeds.
+func TestParsingWherePrecedence(t *testing.T) {
+	q, err := Parse("EVENT a b WHERE b.foo == 'x' AND b.n == 1.0 OR b.foo == 'y'")
+	require.NoError(t, err)
+
+	or, ok := q.where.(*orPredicate)
+	require.True(t, ok, "top level should be an OR, since OR binds loosest")
+	require.Len(t, or.clauses, 2)
+
+	and, ok := or.clauses[0].(*andPredicate)
+	require.True(t, ok, "left OR operand should be the AND group (a AND b) OR c")
+	require.Len(t, and.clauses, 2)
+
+	_, ok = or.clauses[1].(*comparison)
+	require.True(t, ok, "right OR operand should be the bare comparison")
+}
+
+func TestQueryTextParenthesizesOrInsideAnd(t *testing.T) {
+	q, err := Parse("EVENT a b WHERE (b.foo == 'x' OR b.n == 1.0) AND b.foo == 'y'")
+	require.NoError(t, err)
+	require.Contains(t, q.QueryText(), "(b.foo == 'x' OR b.n == 1) AND b.foo == 'y'")
+}
+
+func TestEvalLike(t *testing.T) {
+	q, err := Parse("EVENT a b WHERE b.foo LIKE 'x%z'")
+	require.NoError(t, err)
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	require.True(t, m.Eval(map[string]map[string]any{"b": {"foo": "xyyyz"}}))
+	require.False(t, m.Eval(map[string]map[string]any{"b": {"foo": "yxz"}}))
+}
+
+func TestEvalMatches(t *testing.T) {
+	q, err := Parse("EVENT a b WHERE b.foo MATCHES /^[0-9]+$/")
+	require.NoError(t, err)
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	require.True(t, m.Eval(map[string]map[string]any{"b": {"foo": "12345"}}))
+	require.False(t, m.Eval(map[string]map[string]any{"b": {"foo": "12a45"}}))
+}
+
+func TestEvalIn(t *testing.T) {
+	q, err := Parse("EVENT a b WHERE b.foo IN ('x', 'y')")
+	require.NoError(t, err)
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	require.True(t, m.Eval(map[string]map[string]any{"b": {"foo": "y"}}))
+	require.False(t, m.Eval(map[string]map[string]any{"b": {"foo": "z"}}))
+}
+
+func TestEvalOrShortCircuits(t *testing.T) {
+	q, err := Parse("EVENT a b WHERE b.foo == 'x' OR b.n > 1.0")
+	require.NoError(t, err)
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	require.True(t, m.Eval(map[string]map[string]any{"b": {"foo": "x", "n": 0.0}}))
+	require.True(t, m.Eval(map[string]map[string]any{"b": {"foo": "nope", "n": 2.0}}))
+	require.False(t, m.Eval(map[string]map[string]any{"b": {"foo": "nope", "n": 0.0}}))
+}
+
+func TestEvalNot(t *testing.T) {
+	q, err := Parse("EVENT a b WHERE NOT b.foo == 'x'")
+	require.NoError(t, err)
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	require.True(t, m.Eval(map[string]map[string]any{"b": {"foo": "y"}}))
+	require.False(t, m.Eval(map[string]map[string]any{"b": {"foo": "x"}}))
+}
+
+// TestMatcherPushWhereOr exercises OR short-circuiting at match time, where
+// one disjunct is unresolvable until a later event binds it.
+func TestMatcherPushWhereOr(t *testing.T) {
+	q, err := Parse("EVENT SEQ(t1 e1, t2 e2) WHERE e1.n > 100.0 OR e2.flag == 'y'")
+	require.NoError(t, err)
+	m, err := q.Compile()
+	require.NoError(t, err)
+
+	matches := m.Push(testEvent{typ: "t1", ts: at(0), attrs: map[string]any{"n": 1.0}})
+	require.Empty(t, matches)
+
+	matches = m.Push(testEvent{typ: "t2", ts: at(time.Minute), attrs: map[string]any{"flag": "y"}})
+	require.Len(t, matches, 1)
+}